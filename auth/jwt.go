@@ -0,0 +1,185 @@
+// Package auth verifies the bearer tokens presented to the gateway API.
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// ErrJWTDisabled is returned by Verify when no JWT secret or JWKS file is
+// configured.
+var ErrJWTDisabled = errors.New("JWT authentication is not configured")
+
+// Verifier checks HS256 tokens against a shared secret and RS256 tokens
+// against the RSA keys of a JWKS file.
+type Verifier struct {
+	secret      []byte
+	keys        map[string]*rsa.PublicKey
+	tenantClaim string
+	rolesClaim  string
+	parser      *jwt.Parser
+}
+
+// NewVerifier builds a Verifier from cfg, loading the JWKS file if one is
+// configured.
+func NewVerifier(cfg config.AuthConfig) (*Verifier, error) {
+	v := &Verifier{
+		secret:      []byte(cfg.JWTSecret),
+		tenantClaim: cfg.TenantClaim,
+		rolesClaim:  cfg.RolesClaim,
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+
+	var methods []string
+	if len(v.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if len(v.keys) > 0 {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Enabled reports whether any JWT signing key is configured.
+func (v *Verifier) Enabled() bool {
+	return len(v.secret) > 0 || len(v.keys) > 0
+}
+
+// Verify checks the signature and standard claims of a token and returns
+// the caller it names.
+func (v *Verifier) Verify(token string) (*utils.Principal, error) {
+	if !v.Enabled() {
+		return nil, ErrJWTDisabled
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims.GetSubject()
+	if sub == "" {
+		return nil, errors.New("token has no sub claim")
+	}
+	p := &utils.Principal{Kind: utils.PrincipalJWT, Subject: sub}
+	if name, ok := claims["preferred_username"].(string); ok && name != "" {
+		p.Name = name
+	} else if name, ok := claims["name"].(string); ok {
+		p.Name = name
+	}
+	if v.tenantClaim != "" {
+		p.OrganizationID, _ = claims[v.tenantClaim].(string)
+	}
+	if v.rolesClaim != "" {
+		p.Roles = stringList(claims[v.rolesClaim])
+	}
+	return p, nil
+}
+
+// stringList reads a claim holding either an array of strings or a space
+// separated string.
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func (v *Verifier) key(t *jwt.Token) (interface{}, error) {
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" && len(v.keys) == 1 {
+			for _, k := range v.keys {
+				return k, nil
+			}
+		}
+		if k, ok := v.keys[kid]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
+
+// LoadJWKS reads the RSA signing keys of a JSON Web Key Set file, by key ID.
+// Keys of other types or uses are skipped.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS file %s: %w", path, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: invalid modulus: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("JWKS key %q: invalid exponent", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s has no RSA signing keys", path)
+	}
+	return keys, nil
+}
@@ -0,0 +1,75 @@
+// Command apikey issues an API key for a tenant directly in MongoDB. Every
+// gateway route requires authentication, so this is how the first key is
+// created when no JWT issuer is configured. With -global the key is not
+// bound to a tenant, which editing roles requires.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (default: $CONFIG_FILE)")
+	tenant := flag.String("tenant", "", "SatuSehat organization ID the key acts for (required unless -global)")
+	global := flag.Bool("global", false, "issue a key not bound to a tenant; it picks one with X-Tenant-ID")
+	name := flag.String("name", "", "name of the calling system (required)")
+	roles := flag.String("roles", "ops", "comma-separated roles granted to the key")
+	flag.Parse()
+
+	if *name == "" || (*tenant == "") == !*global {
+		log.Fatal("-name and exactly one of -tenant or -global are required")
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI).SetTimeout(cfg.Mongo.Timeout))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(cfg.Mongo.Database)
+	if err := utils.EnsureDefaultRoles(ctx, db); err != nil {
+		log.Fatal(err)
+	}
+	apiKey, key, err := utils.CreateAPIKey(ctx, db, *name, *tenant, strings.Split(*roles, ","), "cmd/apikey")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The key and its hash are never logged
+	err = utils.LogAudit(ctx, db, models.AuditLog{
+		OrganizationID: apiKey.OrganizationID,
+		User:           "cmd/apikey",
+		Action:         "create",
+		Resource:       "api_key",
+		ResourceID:     apiKey.ID.Hex(),
+		StatusCode:     http.StatusCreated,
+		Details:        map[string]interface{}{"name": apiKey.Name, "roles": apiKey.Roles},
+	})
+	if err != nil {
+		log.Printf("writing audit entry: %v", err)
+	}
+	scope := "tenant " + apiKey.OrganizationID
+	if *global {
+		scope = "all tenants"
+	}
+	log.Printf("issued API key %s (%q) for %s with roles %v; it is shown only once", apiKey.ID.Hex(), apiKey.Name, scope, apiKey.Roles)
+	fmt.Println(key)
+}
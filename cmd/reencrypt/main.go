@@ -0,0 +1,64 @@
+// Command reencrypt encrypts client secrets and access tokens that are still
+// stored in plaintext, and re-wraps values sealed with an old master key
+// under the current primary key. Run it after enabling encryption and after
+// every master key rotation, before removing the old key.
+//
+// Documents from before multi-tenancy are first assigned to default_tenant,
+// because the tenant is part of their encryption context. Without a default
+// tenant they are skipped and reported.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (default: $CONFIG_FILE)")
+	timeout := flag.Duration("timeout", 10*time.Minute, "overall time limit")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := encryption.LoadKeyring(cfg.Encryption.KeysFile, cfg.Encryption.Keys, cfg.Encryption.PrimaryKeyID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI).SetTimeout(cfg.Mongo.Timeout))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(cfg.Mongo.Database)
+	if cfg.DefaultTenant != "" {
+		if err := utils.AdoptLegacyTenant(ctx, db, keys, cfg.DefaultTenant); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	stats, err := utils.Reencrypt(ctx, db, keys)
+	log.Printf("credentials: %d encrypted, %d re-wrapped; tokens: %d encrypted, %d re-wrapped (primary key %q)",
+		stats.CredentialsEncrypted, stats.CredentialsRewrapped, stats.TokensEncrypted, stats.TokensRewrapped, keys.PrimaryKeyID())
+	if stats.CredentialsSkipped > 0 || stats.TokensSkipped > 0 {
+		log.Printf("skipped %d credential(s) and %d token(s) without organization_id: set default_tenant (DEFAULT_TENANT_ID) and run again",
+			stats.CredentialsSkipped, stats.TokensSkipped)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
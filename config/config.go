@@ -0,0 +1,315 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the gateway needs at startup.
+type Config struct {
+	// Mode is the process mode, "development" or "production". Only a process
+	// started in production mode may talk to the production profile.
+	Mode string `yaml:"mode" toml:"mode"`
+	// DefaultTenant is the organization ID used by requests that select no
+	// tenant. Leave empty to require a tenant on every request.
+	DefaultTenant string           `yaml:"default_tenant" toml:"default_tenant"`
+	Server        ServerConfig     `yaml:"server" toml:"server"`
+	Mongo         MongoConfig      `yaml:"mongo" toml:"mongo"`
+	SatuSehat     SatuSehatConfig  `yaml:"satusehat" toml:"satusehat"`
+	Encryption    EncryptionConfig `yaml:"encryption" toml:"encryption"`
+	Auth          AuthConfig       `yaml:"auth" toml:"auth"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// ShutdownTimeout is how long in-flight requests may finish after a
+	// shutdown signal before their contexts are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+type MongoConfig struct {
+	URI      string `yaml:"uri" toml:"uri"`
+	Database string `yaml:"database" toml:"database"`
+	// Timeout bounds every MongoDB operation.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+type SatuSehatConfig struct {
+	// DefaultProfile is used for credentials that do not name a profile.
+	DefaultProfile string             `yaml:"default_profile" toml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles" toml:"profiles"`
+	// Timeout bounds every call to the SatuSehat API except transactions.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// TransactionTimeout bounds transaction Bundle submissions.
+	TransactionTimeout time.Duration `yaml:"transaction_timeout" toml:"transaction_timeout"`
+	// TokenTimeout bounds an OAuth token request.
+	TokenTimeout time.Duration `yaml:"token_timeout" toml:"token_timeout"`
+	// TokenRefreshBefore is how long before expiry the token is refreshed in
+	// the background.
+	TokenRefreshBefore time.Duration `yaml:"token_refresh_before" toml:"token_refresh_before"`
+	// RosterRefresh is how often the IHS IDs of the practitioner roster are
+	// looked up again.
+	RosterRefresh time.Duration `yaml:"roster_refresh" toml:"roster_refresh"`
+	// LegacyBaseURL is the single base URL replaced by Profiles. It is only
+	// read to refuse old configurations that still set it.
+	LegacyBaseURL string `yaml:"base_url" toml:"base_url"`
+}
+
+// EncryptionConfig locates the master keys used to encrypt client secrets
+// and access tokens at rest. Keys are written as "id:base64key", one per
+// line in KeysFile or comma separated in Keys.
+type EncryptionConfig struct {
+	KeysFile string `yaml:"keys_file" toml:"keys_file"`
+	// Keys is meant for the ENCRYPTION_KEYS environment variable; avoid
+	// putting master keys in the config file itself.
+	Keys string `yaml:"keys" toml:"keys"`
+	// PrimaryKeyID selects the key new values are encrypted with. Defaults
+	// to the first key listed.
+	PrimaryKeyID string `yaml:"primary_key_id" toml:"primary_key_id"`
+}
+
+// AuthConfig configures JWT bearer authentication. API keys need no
+// configuration; JWTs are accepted only if a secret or JWKS file is set.
+type AuthConfig struct {
+	// JWTSecret verifies HS256 tokens.
+	JWTSecret string `yaml:"jwt_secret" toml:"jwt_secret"`
+	// JWKSFile holds the public keys that verify RS256 tokens.
+	JWKSFile string `yaml:"jwks_file" toml:"jwks_file"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string `yaml:"issuer" toml:"issuer"`
+	Audience string `yaml:"audience" toml:"audience"`
+	// TenantClaim names the claim that binds a token to one tenant. Tokens
+	// without it may select any tenant with X-Tenant-ID.
+	TenantClaim string `yaml:"tenant_claim" toml:"tenant_claim"`
+	// RolesClaim names the claim listing the caller's roles, as an array
+	// or a space separated string.
+	RolesClaim string `yaml:"roles_claim" toml:"roles_claim"`
+}
+
+// ValidationError lists every missing or invalid value found in a Config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Default returns the configuration used when nothing else is set.
+func Default() *Config {
+	return &Config{
+		Mode:   ModeDevelopment,
+		Server: ServerConfig{Addr: ":8080", ShutdownTimeout: 15 * time.Second},
+		Mongo:  MongoConfig{Database: "satusehat_mirror", Timeout: 5 * time.Second},
+		SatuSehat: SatuSehatConfig{
+			DefaultProfile:     ProfileStaging,
+			Profiles:           DefaultProfiles(),
+			Timeout:            30 * time.Second,
+			TransactionTimeout: 2 * time.Minute,
+			TokenTimeout:       10 * time.Second,
+			TokenRefreshBefore: 5 * time.Minute,
+			RosterRefresh:      24 * time.Hour,
+		},
+		Auth: AuthConfig{TenantClaim: "organization_id", RolesClaim: "roles"},
+	}
+}
+
+// Load builds the configuration from defaults, then the optional file at path
+// (YAML or TOML, chosen by extension), then environment variables. If path is
+// empty, CONFIG_FILE is used.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	var problems []string
+	if path != "" {
+		unknown, err := loadFile(path, cfg)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, unknown...)
+	}
+
+	problems = append(problems, applyEnv(cfg)...)
+
+	if err := cfg.Validate(); err != nil {
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			return nil, err
+		}
+		problems = append(problems, verr.Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// loadFile decodes the file at path into cfg. Keys that do not match a
+// setting, and values of the wrong type, are returned as problems so a typo
+// is reported with the other bad values instead of being ignored.
+func loadFile(path string, cfg *Config) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var problems []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, e := range typeErr.Errors {
+				problems = append(problems, fmt.Sprintf("config file %s: %s", path, e))
+			}
+			err = nil
+		} else if errors.Is(err, io.EOF) {
+			err = nil // empty file
+		}
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		if err == nil {
+			for _, key := range md.Undecoded() {
+				problems = append(problems, fmt.Sprintf("config file %s: unknown key %s", path, key))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format (use .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return problems, nil
+}
+
+// applyEnv overrides cfg from environment variables and returns the
+// variables that could not be parsed.
+func applyEnv(cfg *Config) []string {
+	var problems []string
+	if _, ok := os.LookupEnv("SATUSEHAT_BASE_URL"); ok {
+		problems = append(problems, "SATUSEHAT_BASE_URL is no longer supported: set base_url per environment under satusehat.profiles "+
+			"in the config file and pick one with SATUSEHAT_PROFILE")
+	}
+	setFromEnv(&cfg.Mode, "APP_MODE")
+	setFromEnv(&cfg.DefaultTenant, "DEFAULT_TENANT_ID")
+	setFromEnv(&cfg.Server.Addr, "SERVER_ADDR")
+	setFromEnv(&cfg.Mongo.URI, "MONGO_URI")
+	setFromEnv(&cfg.Mongo.Database, "MONGO_DATABASE")
+	setFromEnv(&cfg.SatuSehat.DefaultProfile, "SATUSEHAT_PROFILE")
+	setFromEnv(&cfg.Encryption.KeysFile, "ENCRYPTION_KEYS_FILE")
+	setFromEnv(&cfg.Encryption.Keys, "ENCRYPTION_KEYS")
+	setFromEnv(&cfg.Encryption.PrimaryKeyID, "ENCRYPTION_PRIMARY_KEY_ID")
+	setFromEnv(&cfg.Auth.JWTSecret, "AUTH_JWT_SECRET")
+	setFromEnv(&cfg.Auth.JWKSFile, "AUTH_JWKS_FILE")
+	setFromEnv(&cfg.Auth.Issuer, "AUTH_JWT_ISSUER")
+	setFromEnv(&cfg.Auth.Audience, "AUTH_JWT_AUDIENCE")
+	setFromEnv(&cfg.Auth.TenantClaim, "AUTH_JWT_TENANT_CLAIM")
+	setFromEnv(&cfg.Auth.RolesClaim, "AUTH_JWT_ROLES_CLAIM")
+	problems = append(problems, setDurationFromEnv(&cfg.Server.ShutdownTimeout, "SERVER_SHUTDOWN_TIMEOUT")...)
+	problems = append(problems, setDurationFromEnv(&cfg.Mongo.Timeout, "MONGO_TIMEOUT")...)
+	problems = append(problems, setDurationFromEnv(&cfg.SatuSehat.Timeout, "SATUSEHAT_TIMEOUT")...)
+	problems = append(problems, setDurationFromEnv(&cfg.SatuSehat.TransactionTimeout, "SATUSEHAT_TRANSACTION_TIMEOUT")...)
+	problems = append(problems, setDurationFromEnv(&cfg.SatuSehat.TokenTimeout, "SATUSEHAT_TOKEN_TIMEOUT")...)
+	problems = append(problems, setDurationFromEnv(&cfg.SatuSehat.TokenRefreshBefore, "SATUSEHAT_TOKEN_REFRESH_BEFORE")...)
+	problems = append(problems, setDurationFromEnv(&cfg.SatuSehat.RosterRefresh, "SATUSEHAT_ROSTER_REFRESH")...)
+	return problems
+}
+
+func setFromEnv(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = strings.TrimSpace(v)
+	}
+}
+
+func setDurationFromEnv(dst *time.Duration, key string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		return []string{fmt.Sprintf("%s %q is not a duration (e.g. 30s, 2m)", key, v)}
+	}
+	*dst = d
+	return nil
+}
+
+// Validate checks the configuration and reports all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Mode != ModeDevelopment && c.Mode != ModeProduction {
+		problems = append(problems, fmt.Sprintf("mode (APP_MODE) %q must be %q or %q", c.Mode, ModeDevelopment, ModeProduction))
+	}
+
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr (SERVER_ADDR) is required")
+	} else if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		problems = append(problems, fmt.Sprintf("server.addr (SERVER_ADDR) %q is not a host:port address", c.Server.Addr))
+	}
+
+	if c.Mongo.URI == "" {
+		problems = append(problems, "mongo.uri (MONGO_URI) is required")
+	} else if !strings.HasPrefix(c.Mongo.URI, "mongodb://") && !strings.HasPrefix(c.Mongo.URI, "mongodb+srv://") {
+		problems = append(problems, fmt.Sprintf("mongo.uri (MONGO_URI) %q must start with mongodb:// or mongodb+srv://", c.Mongo.URI))
+	}
+	if c.Mongo.Database == "" {
+		problems = append(problems, "mongo.database (MONGO_DATABASE) is required")
+	}
+
+	problems = append(problems, c.SatuSehat.validate()...)
+
+	if c.Encryption.KeysFile == "" && c.Encryption.Keys == "" {
+		problems = append(problems, "encryption.keys_file (ENCRYPTION_KEYS_FILE) or ENCRYPTION_KEYS is required")
+	}
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < 32 {
+		problems = append(problems, "auth.jwt_secret (AUTH_JWT_SECRET) must be at least 32 characters")
+	}
+
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"server.shutdown_timeout (SERVER_SHUTDOWN_TIMEOUT)", c.Server.ShutdownTimeout},
+		{"mongo.timeout (MONGO_TIMEOUT)", c.Mongo.Timeout},
+		{"satusehat.timeout (SATUSEHAT_TIMEOUT)", c.SatuSehat.Timeout},
+		{"satusehat.transaction_timeout (SATUSEHAT_TRANSACTION_TIMEOUT)", c.SatuSehat.TransactionTimeout},
+		{"satusehat.token_timeout (SATUSEHAT_TOKEN_TIMEOUT)", c.SatuSehat.TokenTimeout},
+		{"satusehat.token_refresh_before (SATUSEHAT_TOKEN_REFRESH_BEFORE)", c.SatuSehat.TokenRefreshBefore},
+		{"satusehat.roster_refresh (SATUSEHAT_ROSTER_REFRESH)", c.SatuSehat.RosterRefresh},
+	} {
+		if d.value <= 0 {
+			problems = append(problems, d.key+" must be positive")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
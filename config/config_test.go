@@ -0,0 +1,205 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configEnv lists every variable Load reads, so tests start from a clean
+// environment.
+var configEnv = []string{
+	"CONFIG_FILE", "APP_MODE", "DEFAULT_TENANT_ID", "SERVER_ADDR", "MONGO_URI", "MONGO_DATABASE",
+	"SATUSEHAT_PROFILE", "ENCRYPTION_KEYS_FILE", "ENCRYPTION_KEYS", "ENCRYPTION_PRIMARY_KEY_ID",
+	"AUTH_JWT_SECRET", "AUTH_JWKS_FILE", "AUTH_JWT_ISSUER", "AUTH_JWT_AUDIENCE",
+	"AUTH_JWT_TENANT_CLAIM", "AUTH_JWT_ROLES_CLAIM", "SERVER_SHUTDOWN_TIMEOUT", "MONGO_TIMEOUT",
+	"SATUSEHAT_TIMEOUT", "SATUSEHAT_TRANSACTION_TIMEOUT", "SATUSEHAT_TOKEN_TIMEOUT",
+	"SATUSEHAT_TOKEN_REFRESH_BEFORE", "SATUSEHAT_ROSTER_REFRESH", "SATUSEHAT_BASE_URL",
+}
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range configEnv {
+		t.Setenv(key, "") // restores the original value after the test
+		os.Unsetenv(key)
+	}
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const validYAML = `
+mongo:
+  uri: "mongodb://db:27017"
+  database: "mirror"
+  timeout: 3s
+encryption:
+  keys_file: "/etc/satusehat/master.keys"
+`
+
+const validTOML = `
+[mongo]
+uri = "mongodb://db:27017"
+database = "mirror"
+timeout = "3s"
+
+[encryption]
+keys_file = "/etc/satusehat/master.keys"
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string // file name, which selects the format
+		content  string
+		env      map[string]string
+		problems []string // substrings, one per expected problem
+		check    func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:    "yaml file",
+			file:    "config.yaml",
+			content: validYAML,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Mongo.URI != "mongodb://db:27017" || cfg.Mongo.Database != "mirror" || cfg.Mongo.Timeout != 3*time.Second {
+					t.Errorf("mongo = %+v", cfg.Mongo)
+				}
+				if cfg.Server.Addr != ":8080" {
+					t.Errorf("server.addr = %q, want the default", cfg.Server.Addr)
+				}
+			},
+		},
+		{
+			name:    "toml file",
+			file:    "config.toml",
+			content: validTOML,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Mongo.URI != "mongodb://db:27017" || cfg.Mongo.Timeout != 3*time.Second {
+					t.Errorf("mongo = %+v", cfg.Mongo)
+				}
+			},
+		},
+		{
+			name:    "env overrides file",
+			file:    "config.yaml",
+			content: validYAML,
+			env:     map[string]string{"MONGO_DATABASE": "other", "MONGO_TIMEOUT": "9s", "SERVER_ADDR": ":9090"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Mongo.URI != "mongodb://db:27017" {
+					t.Errorf("mongo.uri = %q, want the file value", cfg.Mongo.URI)
+				}
+				if cfg.Mongo.Database != "other" || cfg.Mongo.Timeout != 9*time.Second || cfg.Server.Addr != ":9090" {
+					t.Errorf("env not applied: mongo = %+v, server = %+v", cfg.Mongo, cfg.Server)
+				}
+			},
+		},
+		{
+			name:     "missing required value",
+			file:     "config.yaml",
+			content:  "encryption:\n  keys: \"k1:AAAA\"\n",
+			problems: []string{"mongo.uri (MONGO_URI) is required"},
+		},
+		{
+			name:     "missing value from env only",
+			env:      map[string]string{"ENCRYPTION_KEYS": "k1:AAAA"},
+			problems: []string{"mongo.uri (MONGO_URI) is required"},
+		},
+		{
+			name:     "bad duration in env",
+			file:     "config.yaml",
+			content:  validYAML,
+			env:      map[string]string{"SATUSEHAT_TIMEOUT": "soon"},
+			problems: []string{`SATUSEHAT_TIMEOUT "soon" is not a duration`},
+		},
+		{
+			name:     "bad duration in file",
+			file:     "config.yaml",
+			content:  validYAML + "server:\n  shutdown_timeout: soon\n",
+			problems: []string{"soon"},
+		},
+		{
+			name:     "non-positive duration",
+			file:     "config.yaml",
+			content:  validYAML,
+			env:      map[string]string{"SATUSEHAT_TOKEN_TIMEOUT": "0s"},
+			problems: []string{"satusehat.token_timeout (SATUSEHAT_TOKEN_TIMEOUT) must be positive"},
+		},
+		{
+			name:     "unknown yaml key",
+			file:     "config.yaml",
+			content:  "mongo:\n  urI: \"mongodb://db:27017\"\nencryption:\n  keys: \"k1:AAAA\"\n",
+			problems: []string{"field urI not found", "mongo.uri (MONGO_URI) is required"},
+		},
+		{
+			name:     "unknown toml key",
+			file:     "config.toml",
+			content:  validTOML + "\n[server]\nadress = \":9090\"\n",
+			problems: []string{"unknown key server.adress"},
+		},
+		{
+			name:     "every problem at once",
+			file:     "config.yaml",
+			content:  "mode: staging\nmongo:\n  uri: \"localhost\"\n",
+			env:      map[string]string{"MONGO_TIMEOUT": "x"},
+			problems: []string{"MONGO_TIMEOUT", "mode (APP_MODE)", "must start with mongodb://", "encryption.keys_file"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var path string
+			if tt.file != "" {
+				path = writeConfig(t, tt.file, tt.content)
+			}
+
+			cfg, err := Load(path)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				if tt.check != nil {
+					tt.check(t, cfg)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Load error = %v, want a ValidationError", err)
+			}
+			for _, want := range tt.problems {
+				found := false
+				for _, p := range verr.Problems {
+					if strings.Contains(p, want) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("no problem mentions %q; got:\n%s", want, verr)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadUnsupportedFormat(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, "config.json", "{}")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Fatalf("Load error = %v, want unsupported format", err)
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
+
+	ProfileSandbox    = "sandbox"
+	ProfileStaging    = "staging"
+	ProfileProduction = "production"
+)
+
+// productionHosts are the hosts of the real SatuSehat environment. A profile
+// pointing at any of them is treated as production whatever its name.
+var productionHosts = map[string]bool{
+	"api-satusehat.kemkes.go.id": true,
+}
+
+// ErrProductionNotAllowed is returned when a production profile is requested
+// by a process that was not started in production mode.
+var ErrProductionNotAllowed = errors.New("production profile is not allowed: process is not running in production mode")
+
+// Profile bundles the SatuSehat endpoints of one environment.
+type Profile struct {
+	Name       string `yaml:"-" toml:"-"`
+	AuthURL    string `yaml:"auth_url" toml:"auth_url"`
+	BaseURL    string `yaml:"base_url" toml:"base_url"`
+	ConsentURL string `yaml:"consent_url" toml:"consent_url"`
+	KYCURL     string `yaml:"kyc_url" toml:"kyc_url"`
+	// Production marks a profile that carries real patient data.
+	Production bool `yaml:"production" toml:"production"`
+}
+
+// IsProduction reports whether the profile is marked production or sends
+// to a production host.
+func (p Profile) IsProduction() bool {
+	if p.Production {
+		return true
+	}
+	for _, raw := range []string{p.AuthURL, p.BaseURL, p.ConsentURL, p.KYCURL} {
+		if u, err := url.Parse(raw); err == nil && productionHosts[strings.ToLower(u.Hostname())] {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenURL is the client-credentials endpoint of the profile.
+func (p Profile) TokenURL() string {
+	return p.AuthURL + "/accesstoken?grant_type=client_credentials"
+}
+
+// DefaultProfiles returns the public SatuSehat environments.
+func DefaultProfiles() map[string]Profile {
+	return map[string]Profile{
+		ProfileSandbox: {
+			AuthURL:    "https://api-satusehat-dev.dto.kemkes.go.id/oauth2/v1",
+			BaseURL:    "https://api-satusehat-dev.dto.kemkes.go.id/fhir-r4/v1",
+			ConsentURL: "https://api-satusehat-dev.dto.kemkes.go.id/consent/v1",
+			KYCURL:     "https://api-satusehat-dev.dto.kemkes.go.id/kyc/v1",
+		},
+		ProfileStaging: {
+			AuthURL:    "https://api-satusehat-stg.dto.kemkes.go.id/oauth2/v1",
+			BaseURL:    "https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1",
+			ConsentURL: "https://api-satusehat-stg.dto.kemkes.go.id/consent/v1",
+			KYCURL:     "https://api-satusehat-stg.dto.kemkes.go.id/kyc/v1",
+		},
+		ProfileProduction: {
+			AuthURL:    "https://api-satusehat.kemkes.go.id/oauth2/v1",
+			BaseURL:    "https://api-satusehat.kemkes.go.id/fhir-r4/v1",
+			ConsentURL: "https://api-satusehat.kemkes.go.id/consent/v1",
+			KYCURL:     "https://api-satusehat.kemkes.go.id/kyc/v1",
+			Production: true,
+		},
+	}
+}
+
+// Profile resolves a profile by name; an empty name selects the default
+// profile. Production profiles, and any profile pointing at a production
+// host, are refused unless the process runs in production mode.
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.SatuSehat.DefaultProfile
+	}
+	p, ok := c.SatuSehat.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown SatuSehat profile %q", name)
+	}
+	p.Name = name
+	if p.IsProduction() && c.Mode != ModeProduction {
+		return Profile{}, ErrProductionNotAllowed
+	}
+	return p, nil
+}
+
+func (s *SatuSehatConfig) validate() []string {
+	var problems []string
+
+	if s.LegacyBaseURL != "" {
+		problems = append(problems, "satusehat.base_url is no longer supported: set base_url per environment under satusehat.profiles "+
+			"(e.g. satusehat.profiles.staging.base_url) and pick one with satusehat.default_profile")
+	}
+	if len(s.Profiles) == 0 {
+		problems = append(problems, "satusehat.profiles must define at least one profile")
+	}
+
+	names := make([]string, 0, len(s.Profiles))
+	for name := range s.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := s.Profiles[name]
+		for _, f := range []struct{ key, value string }{
+			{"auth_url", p.AuthURL},
+			{"base_url", p.BaseURL},
+			{"consent_url", p.ConsentURL},
+			{"kyc_url", p.KYCURL},
+		} {
+			if f.value == "" {
+				problems = append(problems, fmt.Sprintf("satusehat.profiles.%s.%s is required", name, f.key))
+			} else if !isHTTPURL(f.value) {
+				problems = append(problems, fmt.Sprintf("satusehat.profiles.%s.%s %q is not a valid http(s) URL", name, f.key, f.value))
+			}
+		}
+		p.AuthURL = strings.TrimRight(p.AuthURL, "/")
+		p.BaseURL = strings.TrimRight(p.BaseURL, "/")
+		p.ConsentURL = strings.TrimRight(p.ConsentURL, "/")
+		p.KYCURL = strings.TrimRight(p.KYCURL, "/")
+		if name == ProfileProduction || p.IsProduction() {
+			p.Production = true
+		}
+		s.Profiles[name] = p
+	}
+
+	if s.DefaultProfile == "" {
+		problems = append(problems, "satusehat.default_profile (SATUSEHAT_PROFILE) is required")
+	} else if _, ok := s.Profiles[s.DefaultProfile]; !ok {
+		problems = append(problems, fmt.Sprintf("satusehat.default_profile (SATUSEHAT_PROFILE) %q is not a defined profile", s.DefaultProfile))
+	}
+
+	return problems
+}
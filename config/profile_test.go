@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProfileProductionGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		mode    string
+		wantErr bool
+	}{
+		{"staging in development", DefaultProfiles()[ProfileStaging], ModeDevelopment, false},
+		{"production in development", DefaultProfiles()[ProfileProduction], ModeDevelopment, true},
+		{"production in production", DefaultProfiles()[ProfileProduction], ModeProduction, false},
+		{"custom profile on production host", Profile{
+			AuthURL:    "https://api-satusehat.kemkes.go.id/oauth2/v1",
+			BaseURL:    "https://api-satusehat.kemkes.go.id/fhir-r4/v1",
+			ConsentURL: "https://api-satusehat-stg.dto.kemkes.go.id/consent/v1",
+			KYCURL:     "https://api-satusehat-stg.dto.kemkes.go.id/kyc/v1",
+		}, ModeDevelopment, true},
+		{"one production URL is enough", Profile{
+			AuthURL:    "https://api-satusehat-stg.dto.kemkes.go.id/oauth2/v1",
+			BaseURL:    "https://API-SATUSEHAT.kemkes.go.id:443/fhir-r4/v1",
+			ConsentURL: "https://api-satusehat-stg.dto.kemkes.go.id/consent/v1",
+			KYCURL:     "https://api-satusehat-stg.dto.kemkes.go.id/kyc/v1",
+		}, ModeDevelopment, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Mode = tt.mode
+			cfg.SatuSehat.Profiles["custom"] = tt.profile
+			if problems := cfg.SatuSehat.validate(); len(problems) > 0 {
+				t.Fatalf("validate: %v", problems)
+			}
+			_, err := cfg.Profile("custom")
+			if got := errors.Is(err, ErrProductionNotAllowed); got != tt.wantErr {
+				t.Fatalf("Profile error = %v, want production refused: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLegacyBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    string
+	}{
+		{"file", validYAML + "satusehat:\n  base_url: \"https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1\"\n", nil, "satusehat.base_url is no longer supported"},
+		{"env", validYAML, map[string]string{"SATUSEHAT_BASE_URL": "https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1"}, "SATUSEHAT_BASE_URL is no longer supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(writeConfig(t, "config.yaml", tt.content))
+			var verr *ValidationError
+			if !errors.As(err, &verr) || !strings.Contains(verr.Error(), tt.want) || !strings.Contains(verr.Error(), "profiles") {
+				t.Fatalf("Load error = %v, want %q pointing to profiles", err, tt.want)
+			}
+		})
+	}
+}
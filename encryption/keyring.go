@@ -0,0 +1,237 @@
+// Package encryption implements envelope encryption for values stored in
+// MongoDB. Every value is encrypted with its own random data key (AES-256-GCM),
+// and the data key is wrapped with a master key from the Keyring. Rotating
+// the master key only needs the data keys to be re-wrapped.
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const keySize = 32 // AES-256
+
+// ErrUnknownKey is returned when a value was sealed with a master key that
+// is not in the keyring.
+var ErrUnknownKey = errors.New("encryption: unknown master key")
+
+// Sealed is an encrypted value as stored in MongoDB.
+type Sealed struct {
+	KeyID      string `bson:"kid"`        // master key that wrapped DataKey
+	DataKey    []byte `bson:"data_key"`   // nonce || data key sealed with the master key
+	Ciphertext []byte `bson:"ciphertext"` // nonce || value sealed with the data key
+}
+
+// Keyring holds the master keys. New values are always sealed with the
+// primary key; the others are kept to open values sealed before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// NewKeyring builds a keyring from master keys indexed by key ID.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("encryption: no master keys")
+	}
+	for id, k := range keys {
+		if len(k) != keySize {
+			return nil, fmt.Errorf("encryption: master key %q must be %d bytes, got %d", id, keySize, len(k))
+		}
+	}
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("encryption: primary key %q is not in the keyring", primary)
+	}
+	return &Keyring{primary: primary, keys: keys}, nil
+}
+
+// ParseKeys parses master keys written as "id:base64key", one per line or
+// separated by commas. Blank lines and lines starting with # are ignored.
+// The first key is returned as the default primary key ID.
+func ParseKeys(r io.Reader) (map[string][]byte, string, error) {
+	keys := map[string][]byte{}
+	first := ""
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		for _, entry := range strings.Split(sc.Text(), ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || strings.HasPrefix(entry, "#") {
+				continue
+			}
+			id, encoded, ok := strings.Cut(entry, ":")
+			if !ok || id == "" {
+				return nil, "", fmt.Errorf("encryption: master key entry must be id:base64key")
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, "", fmt.Errorf("encryption: master key %q: %w", id, err)
+			}
+			if _, dup := keys[id]; dup {
+				return nil, "", fmt.Errorf("encryption: master key %q listed twice", id)
+			}
+			keys[id] = key
+			if first == "" {
+				first = id
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, "", err
+	}
+	return keys, first, nil
+}
+
+// LoadKeyring reads master keys from keysFile and/or the inline keys string
+// (both in ParseKeys format). primary defaults to the first key listed.
+func LoadKeyring(keysFile, inline, primary string) (*Keyring, error) {
+	keys := map[string][]byte{}
+	first := ""
+
+	add := func(r io.Reader) error {
+		parsed, f, err := ParseKeys(r)
+		if err != nil {
+			return err
+		}
+		for id, k := range parsed {
+			if _, dup := keys[id]; dup {
+				return fmt.Errorf("encryption: master key %q listed twice", id)
+			}
+			keys[id] = k
+		}
+		if first == "" {
+			first = f
+		}
+		return nil
+	}
+
+	if keysFile != "" {
+		f, err := os.Open(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: open keys file: %w", err)
+		}
+		defer f.Close()
+		if err := add(f); err != nil {
+			return nil, err
+		}
+	}
+	if inline != "" {
+		if err := add(strings.NewReader(inline)); err != nil {
+			return nil, err
+		}
+	}
+
+	if primary == "" {
+		primary = first
+	}
+	return NewKeyring(primary, keys)
+}
+
+// GenerateKey returns a new random master key, base64 encoded.
+func GenerateKey() (string, error) {
+	k := make([]byte, keySize)
+	if _, err := rand.Read(k); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k), nil
+}
+
+// PrimaryKeyID returns the ID of the key new values are sealed with.
+func (k *Keyring) PrimaryKeyID() string { return k.primary }
+
+// Seal encrypts plaintext. aad binds the value to its context (for example
+// the document and field it is stored in); the same aad must be given to Open.
+func (k *Keyring) Seal(plaintext, aad []byte) (*Sealed, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return nil, err
+	}
+	return &Sealed{KeyID: k.primary, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts a sealed value.
+func (k *Keyring) Open(s *Sealed, aad []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, s.Ciphertext, aad)
+}
+
+// NeedsRewrap reports whether s was sealed with a key other than the primary.
+func (k *Keyring) NeedsRewrap(s *Sealed) bool {
+	return s.KeyID != k.primary
+}
+
+// Rewrap re-wraps the data key of s with the primary key. The ciphertext is
+// left untouched.
+func (k *Keyring) Rewrap(s *Sealed) (*Sealed, error) {
+	dataKey, err := k.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return nil, err
+	}
+	return &Sealed{KeyID: k.primary, DataKey: wrapped, Ciphertext: s.Ciphertext}, nil
+}
+
+func (k *Keyring) unwrap(s *Sealed) ([]byte, error) {
+	master, ok := k.keys[s.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, s.KeyID)
+	}
+	return open(master, s.DataKey, []byte(s.KeyID))
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encryption: ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
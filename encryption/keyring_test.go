@@ -0,0 +1,191 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func mustKeyring(t *testing.T, primary string, keys map[string][]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+var aad = []byte("credentials/10000004/client_secret")
+
+func TestSealOpen(t *testing.T) {
+	k := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	sealed, err := k.Seal([]byte("s3cret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed.KeyID != "k1" || bytes.Contains(sealed.Ciphertext, []byte("s3cret")) {
+		t.Fatalf("sealed = %+v", sealed)
+	}
+	got, err := k.Open(sealed, aad)
+	if err != nil || string(got) != "s3cret" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+
+	again, _ := k.Seal([]byte("s3cret"), aad)
+	if bytes.Equal(again.Ciphertext, sealed.Ciphertext) || bytes.Equal(again.DataKey, sealed.DataKey) {
+		t.Error("sealing twice gave the same ciphertext or data key")
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	k := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	sealed, err := k.Seal([]byte("s3cret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flip := func(b []byte, i int) []byte {
+		c := append([]byte(nil), b...)
+		c[i] ^= 0x01
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		sealed  *Sealed
+		aad     []byte
+		wantErr error // nil means any error
+	}{
+		{"wrong aad", sealed, []byte("credentials/other/client_secret"), nil},
+		{"empty aad", sealed, nil, nil},
+		{"unknown kid", &Sealed{KeyID: "retired", DataKey: sealed.DataKey, Ciphertext: sealed.Ciphertext}, aad, ErrUnknownKey},
+		{"other known kid", &Sealed{KeyID: "k2", DataKey: sealed.DataKey, Ciphertext: sealed.Ciphertext}, aad, nil},
+		{"tampered ciphertext", &Sealed{KeyID: "k1", DataKey: sealed.DataKey, Ciphertext: flip(sealed.Ciphertext, len(sealed.Ciphertext)-1)}, aad, nil},
+		{"tampered nonce", &Sealed{KeyID: "k1", DataKey: sealed.DataKey, Ciphertext: flip(sealed.Ciphertext, 0)}, aad, nil},
+		{"tampered data key", &Sealed{KeyID: "k1", DataKey: flip(sealed.DataKey, 20), Ciphertext: sealed.Ciphertext}, aad, nil},
+		{"truncated ciphertext", &Sealed{KeyID: "k1", DataKey: sealed.DataKey, Ciphertext: sealed.Ciphertext[:4]}, aad, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.Open(tt.sealed, tt.aad)
+			if err == nil {
+				t.Fatalf("Open succeeded with %q", got)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old := mustKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	sealed, err := old.Seal([]byte("s3cret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustKeyring(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if !rotated.NeedsRewrap(sealed) {
+		t.Fatal("value sealed with k1 does not need a rewrap under primary k2")
+	}
+	// Still readable before the rewrap
+	if got, err := rotated.Open(sealed, aad); err != nil || string(got) != "s3cret" {
+		t.Fatalf("Open before rewrap = %q, %v", got, err)
+	}
+
+	rewrapped, err := rotated.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped.KeyID != "k2" || rotated.NeedsRewrap(rewrapped) {
+		t.Fatalf("rewrapped with %q", rewrapped.KeyID)
+	}
+	if !bytes.Equal(rewrapped.Ciphertext, sealed.Ciphertext) {
+		t.Error("rewrap changed the ciphertext")
+	}
+
+	// Once rewrapped, the old key can be dropped
+	retired := mustKeyring(t, "k2", map[string][]byte{"k2": testKey(2)})
+	if got, err := retired.Open(rewrapped, aad); err != nil || string(got) != "s3cret" {
+		t.Fatalf("Open after retiring k1 = %q, %v", got, err)
+	}
+	if _, err := retired.Open(sealed, aad); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Open of a k1 value without k1 = %v, want ErrUnknownKey", err)
+	}
+	if _, err := retired.Rewrap(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Rewrap of a k1 value without k1 = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary string
+		keys    map[string][]byte
+	}{
+		{"no keys", "k1", nil},
+		{"short key", "k1", map[string][]byte{"k1": make([]byte, 16)}},
+		{"primary missing", "k2", map[string][]byte{"k1": testKey(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.primary, tt.keys); err == nil {
+				t.Fatal("NewKeyring accepted an invalid keyring")
+			}
+		})
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+	file := filepath.Join(t.TempDir(), "master.keys")
+	if err := os.WriteFile(file, []byte("# master keys\nk1:"+k1+"\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		file        string
+		inline      string
+		primary     string
+		wantPrimary string
+		wantErr     string
+	}{
+		{"file", file, "", "", "k1", ""},
+		{"file and inline", file, "k2:" + k2, "k2", "k2", ""},
+		{"inline list", "", "k2:" + k2 + ", k1:" + k1, "", "k2", ""},
+		{"duplicate across sources", file, "k1:" + k1, "", "", "listed twice"},
+		{"bad base64", "", "k1:not-base64!", "", "", "k1"},
+		{"missing id", "", ":" + k1, "", "", "id:base64key"},
+		{"missing file", filepath.Join(t.TempDir(), "none"), "", "", "", "open keys file"},
+		{"unknown primary", file, "", "k9", "", "primary key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := LoadKeyring(tt.file, tt.inline, tt.primary)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadKeyring error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.PrimaryKeyID() != tt.wantPrimary {
+				t.Fatalf("primary = %q, want %q", k.PrimaryKeyID(), tt.wantPrimary)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+)
+
+// FHIR value sets of AllergyIntolerance.
+var (
+	allergyCategories    = map[string]bool{"food": true, "medication": true, "environment": true, "biologic": true}
+	allergyCriticalities = map[string]bool{"low": true, "high": true, "unable-to-assess": true}
+	allergyTypes         = map[string]bool{"allergy": true, "intolerance": true}
+)
+
+func allergyCategoryProblems(value interface{}, path string) []string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return []string{path + " must be a list of food, medication, environment or biologic"}
+	}
+	var problems []string
+	for i, item := range list {
+		if category, _ := item.(string); !allergyCategories[category] {
+			problems = append(problems, fmt.Sprintf("%s[%d] %v must be food, medication, environment or biologic", path, i, item))
+		}
+	}
+	return problems
+}
+
+func allergyCriticalityProblems(value interface{}) []string {
+	if criticality, _ := value.(string); !allergyCriticalities[criticality] {
+		return []string{fmt.Sprintf("criticality %v must be low, high or unable-to-assess", value)}
+	}
+	return nil
+}
+
+// validateAllergyIntolerance checks category and criticality against the
+// FHIR value sets, and the patient and encounter references.
+func validateAllergyIntolerance(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "AllergyIntolerance" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be AllergyIntolerance", rt))
+	}
+	if category, ok := resource["category"]; ok {
+		problems = append(problems, allergyCategoryProblems(category, "category")...)
+	} else {
+		problems = append(problems, "category is required")
+	}
+	if criticality, ok := resource["criticality"]; ok {
+		problems = append(problems, allergyCriticalityProblems(criticality)...)
+	}
+	if t, ok := resource["type"]; ok {
+		if s, _ := t.(string); !allergyTypes[s] {
+			problems = append(problems, fmt.Sprintf("type %v must be allergy or intolerance", t))
+		}
+	}
+	if !hasCoding(resource, "code") {
+		problems = append(problems, "code.coding must hold at least one code")
+	}
+	if _, problem := referenceID(resource, "patient", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	if _, ok := resource["encounter"]; ok {
+		problems = append(problems, checkEncounterReference(ctx, db, resource)...)
+	}
+	return problems
+}
+
+// validateAllergyIntolerancePatch checks the values patch operations write
+// to category and criticality.
+func validateAllergyIntolerancePatch(ops []map[string]interface{}) []string {
+	var problems []string
+	for i, op := range ops {
+		kind, _ := op["op"].(string)
+		if kind != "add" && kind != "replace" {
+			continue
+		}
+		path, _ := op["path"].(string)
+		value := op["value"]
+		switch {
+		case path == "/criticality":
+			problems = append(problems, allergyCriticalityProblems(value)...)
+		case path == "/category":
+			problems = append(problems, allergyCategoryProblems(value, fmt.Sprintf("patch[%d] category", i))...)
+		case strings.HasPrefix(path, "/category/"):
+			if category, _ := value.(string); !allergyCategories[category] {
+				problems = append(problems, fmt.Sprintf("patch[%d] %s %v must be food, medication, environment or biologic", i, path, value))
+			}
+		}
+	}
+	return problems
+}
+
+func CreateAllergyIntolerance(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, allergyIntoleranceResource)
+}
+
+func UpdateAllergyIntolerance(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, allergyIntoleranceResource)
+}
+
+func PatchAllergyIntolerance(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, allergyIntoleranceResource)
+}
+
+func GetAllergyIntolerance(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, allergyIntoleranceResource)
+}
+
+// SearchAllergyIntolerance searches the allergies of one patient
+// (?patient=<IHS number>).
+func SearchAllergyIntolerance(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	search := searchResource(db, ss, allergyIntoleranceResource)
+	return func(c echo.Context) error {
+		if c.QueryParam("patient") == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "patient is required"})
+		}
+		return search(c)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateAllergyIntolerancePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ops     string
+		problem string // substring of the only problem expected, "" for none
+	}{
+		{"replace criticality", `[{"op":"replace","path":"/criticality","value":"high"}]`, ""},
+		{"add category list", `[{"op":"add","path":"/category","value":["food","biologic"]}]`, ""},
+		{"add one category", `[{"op":"add","path":"/category/-","value":"medication"}]`, ""},
+		{"other fields", `[{"op":"replace","path":"/note","value":[{"text":"ok"}]}]`, ""},
+		{"remove is not checked", `[{"op":"remove","path":"/criticality"}]`, ""},
+		{"test is not checked", `[{"op":"test","path":"/criticality","value":"severe"}]`, ""},
+		{"bad criticality", `[{"op":"replace","path":"/criticality","value":"severe"}]`, "criticality severe"},
+		{"category not a list", `[{"op":"replace","path":"/category","value":"food"}]`, "patch[0] category must be a list"},
+		{"bad category in list", `[{"op":"add","path":"/category","value":["food","drink"]}]`, "patch[0] category[1] drink"},
+		{"bad single category", `[{"op":"add","path":"/category/0","value":"drink"}]`, "patch[0] /category/0 drink"},
+		{"index of the bad op", `[{"op":"replace","path":"/note","value":[]},{"op":"add","path":"/category/-","value":"drink"}]`, "patch[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+			problems := validateAllergyIntolerancePatch(ops)
+			if tt.problem == "" {
+				if len(problems) > 0 {
+					t.Fatalf("problems = %v, want none", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+				t.Fatalf("problems = %v, want one containing %q", problems, tt.problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/middleware"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// CreateAPIKey issues an API key for the tenant with the given roles. The
+// key is only returned in this response; Mongo keeps its hash. Callers can
+// only grant roles they hold themselves, unless they hold "*" on "*".
+func CreateAPIKey(db *mongo.Database, policies *utils.PolicyStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req struct {
+			Name  string   `json:"name"`
+			Roles []string `json:"roles"`
+		}
+		if err := c.Bind(&req); err != nil || req.Name == "" || len(req.Roles) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "name and roles are required"})
+		}
+
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+
+		unknown, err := policies.Unknown(ctx, req.Roles)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load roles"})
+		}
+		if len(unknown) > 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Unknown roles", "roles": unknown})
+		}
+		denied, err := policies.Ungrantable(ctx, utils.PrincipalFrom(ctx), req.Roles)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load roles"})
+		}
+		if len(denied) > 0 {
+			return middleware.Deny(c, db, "api_key", "create", "Cannot grant roles you do not hold: "+strings.Join(denied, ", "),
+				map[string]interface{}{"requested_roles": req.Roles, "ungrantable_roles": denied})
+		}
+
+		apiKey, key, err := utils.CreateAPIKey(ctx, db, req.Name, tenantID, req.Roles, utils.UserFrom(ctx))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store API key"})
+		}
+		auditAPIKey(ctx, db, "create", apiKey, http.StatusCreated)
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"api_key": apiKey,
+			"key":     key,
+		})
+	}
+}
+
+// ListAPIKeys lists the API keys of the tenant, without the keys themselves.
+func ListAPIKeys(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+
+		cur, err := db.Collection("api_keys").Find(ctx, bson.M{"organization_id": tenantID})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch API keys"})
+		}
+		defer cur.Close(ctx)
+
+		keys := []models.APIKey{}
+		if err := cur.All(ctx, &keys); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode API keys"})
+		}
+		return c.JSON(http.StatusOK, keys)
+	}
+}
+
+// DeleteAPIKey revokes an API key of the tenant.
+func DeleteAPIKey(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid API key ID"})
+		}
+
+		var apiKey models.APIKey
+		err = db.Collection("api_keys").FindOneAndDelete(ctx, bson.M{"_id": id, "organization_id": tenantID}).Decode(&apiKey)
+		if err == mongo.ErrNoDocuments {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete API key"})
+		}
+		auditAPIKey(ctx, db, "delete", &apiKey, http.StatusOK)
+		return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+	}
+}
+
+// auditAPIKey records an API key being issued or revoked. The key and its
+// hash are never logged.
+func auditAPIKey(ctx context.Context, db *mongo.Database, action string, apiKey *models.APIKey, statusCode int) {
+	_ = utils.LogAudit(ctx, db, models.AuditLog{
+		OrganizationID: apiKey.OrganizationID,
+		Action:         action,
+		Resource:       "api_key",
+		ResourceID:     apiKey.ID.Hex(),
+		StatusCode:     statusCode,
+		Details: map[string]interface{}{
+			"name":  apiKey.Name,
+			"roles": apiKey.Roles,
+		},
+	})
+}
@@ -1,16 +1,16 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
-	"satusehat-golang/models"
-	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
 )
 
 // decodeResponseField decodes the BSON binary response field from the audit log details
@@ -34,10 +34,15 @@ func decodeResponseField(log *models.AuditLog) error {
 
 func ListAuditLogs(db *mongo.Database) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		ctx := c.Request().Context()
 
+		// Only an explicit cross-tenant read may see every tenant's entries
 		filter := bson.M{}
+		if tenantID := utils.TenantFrom(ctx); tenantID != "" {
+			filter["organization_id"] = tenantID
+		} else if !utils.AllTenantsFrom(ctx) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
 		resource := c.QueryParam("resource")
 		if resource != "" {
 			filter["resource"] = resource
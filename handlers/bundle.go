@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// SubmitBundle posts a transaction Bundle to SatuSehat. The submission is
+// bound to the request context, so it is aborted when the caller disconnects.
+func SubmitBundle(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read body"})
+		}
+
+		ctx := c.Request().Context()
+		resp, err := ss.Transaction(ctx, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Caller is gone; nothing useful can be written back.
+				return nil
+			}
+			return upstreamError(c, err)
+		}
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "transaction",
+			Resource:   "bundle",
+			StatusCode: resp.StatusCode,
+			Details: map[string]interface{}{
+				"requestBody":  json.RawMessage(body),
+				"responseBody": json.RawMessage(resp.Body),
+			},
+		})
+
+		return c.JSONBlob(resp.StatusCode, resp.Body)
+	}
+}
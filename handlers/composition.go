@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+var compositionStatuses = map[string]bool{
+	"preliminary": true, "final": true, "amended": true, "entered-in-error": true,
+}
+
+// validateComposition checks a Composition before it is sent.
+func validateComposition(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Composition" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Composition", rt))
+	}
+	if status, _ := resource["status"].(string); !compositionStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not a composition status", status))
+	}
+	if !hasCoding(resource, "type") {
+		problems = append(problems, "type.coding must hold at least one code (LOINC)")
+	}
+	if authors, _ := resource["author"].([]interface{}); len(authors) == 0 {
+		problems = append(problems, "author must name at least one practitioner")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	return append(problems, checkEncounterReference(ctx, db, resource)...)
+}
+
+func CreateComposition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, compositionResource)
+}
+
+func UpdateComposition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, compositionResource)
+}
+
+func GetComposition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, compositionResource)
+}
+
+func SearchComposition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, compositionResource)
+}
+
+// loincCode is a LOINC code with its display.
+type loincCode struct{ Code, Display string }
+
+// noteKinds are the document types the note endpoint can build.
+var noteKinds = map[string]loincCode{
+	"progress":  {"11506-3", "Progress note"},
+	"discharge": {"18842-5", "Discharge summary"},
+	"diet":      {"18842-5", "Discharge summary"},
+}
+
+// noteSections codes the section headings recognised in a note, by
+// lower-cased heading.
+var noteSections = map[string]loincCode{
+	"diet":         {"42344-2", "Discharge diet (narrative)"},
+	"s":            {"61150-9", "Subjective Narrative"},
+	"subjective":   {"61150-9", "Subjective Narrative"},
+	"o":            {"61149-1", "Objective Narrative"},
+	"objective":    {"61149-1", "Objective Narrative"},
+	"a":            {"51848-0", "Evaluation note"},
+	"assessment":   {"51848-0", "Evaluation note"},
+	"p":            {"18776-5", "Plan of care note"},
+	"plan":         {"18776-5", "Plan of care note"},
+	"instructions": {"69730-0", "Instructions"},
+	"instruksi":    {"69730-0", "Instructions"},
+}
+
+// ClinicalNote is the payload of the note endpoint.
+type ClinicalNote struct {
+	EncounterID string `json:"encounter_id"`
+	// PractitionerID is the IHS ID of the author.
+	PractitionerID string `json:"practitioner_id"`
+	// Kind is progress (default), discharge or diet.
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+	// Note is free text. A line such as "Plan:" or "Diet:" starts a new
+	// section; text before the first heading forms the first section.
+	Note string `json:"note"`
+	// Identifier is the SIMRS document number, if any.
+	Identifier string `json:"identifier"`
+}
+
+type noteSection struct {
+	Heading string
+	Lines   []string
+}
+
+// sections splits the note at heading lines. A heading with no text under
+// it is dropped: FHIR (txt-2) requires every section narrative to have
+// content.
+func (n *ClinicalNote) sections() []noteSection {
+	var out []noteSection
+	current := noteSection{}
+	for _, line := range strings.Split(strings.ReplaceAll(n.Note, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if heading, rest, ok := strings.Cut(trimmed, ":"); ok && isHeading(heading, rest) {
+			if len(current.Lines) > 0 {
+				out = append(out, current)
+			}
+			current = noteSection{Heading: strings.TrimSpace(heading)}
+			if rest = strings.TrimSpace(rest); rest != "" {
+				current.Lines = append(current.Lines, rest)
+			}
+			continue
+		}
+		if trimmed != "" {
+			current.Lines = append(current.Lines, trimmed)
+		}
+	}
+	if len(current.Lines) > 0 {
+		out = append(out, current)
+	}
+	return out
+}
+
+// isHeading reports whether heading, the text before a colon, starts a
+// section: a known heading, or a short label alone on its line.
+func isHeading(heading, rest string) bool {
+	heading = strings.TrimSpace(heading)
+	if _, ok := noteSections[strings.ToLower(heading)]; ok {
+		return true
+	}
+	return heading != "" && strings.TrimSpace(rest) == "" && len(strings.Fields(heading)) <= 4
+}
+
+// composition builds the Composition for the note.
+func (n *ClinicalNote) composition(tenantID, patientID string, kind loincCode, sections []noteSection) map[string]interface{} {
+	title := n.Title
+	if title == "" {
+		title = kind.Display
+	}
+
+	var section []interface{}
+	for _, s := range sections {
+		heading := s.Heading
+		code, known := noteSections[strings.ToLower(heading)]
+		if heading == "" {
+			heading = title
+			if n.Kind == "diet" {
+				code, known = noteSections["diet"], true
+			}
+		}
+		var div strings.Builder
+		div.WriteString(`<div xmlns="http://www.w3.org/1999/xhtml">`)
+		for i, line := range s.Lines {
+			if i > 0 {
+				div.WriteString("<br/>")
+			}
+			div.WriteString(html.EscapeString(line))
+		}
+		div.WriteString("</div>")
+
+		entry := map[string]interface{}{
+			"title": heading,
+			"text":  map[string]interface{}{"status": "additional", "div": div.String()},
+		}
+		if known {
+			entry["code"] = map[string]interface{}{
+				"coding": []interface{}{map[string]interface{}{"system": loincSystem, "code": code.Code, "display": code.Display}},
+			}
+		}
+		section = append(section, entry)
+	}
+
+	comp := map[string]interface{}{
+		"resourceType": "Composition",
+		"status":       "final",
+		"type": map[string]interface{}{
+			"coding": []interface{}{map[string]interface{}{"system": loincSystem, "code": kind.Code, "display": kind.Display}},
+		},
+		"category": []interface{}{map[string]interface{}{
+			"coding": []interface{}{map[string]interface{}{"system": loincSystem, "code": "LP173421-1", "display": "Report"}},
+		}},
+		"subject":   map[string]interface{}{"reference": "Patient/" + patientID},
+		"encounter": map[string]interface{}{"reference": "Encounter/" + n.EncounterID},
+		"date":      time.Now().Format(time.RFC3339),
+		"author":    []interface{}{map[string]interface{}{"reference": "Practitioner/" + n.PractitionerID}},
+		"title":     title,
+		"custodian": map[string]interface{}{"reference": "Organization/" + tenantID},
+		"section":   section,
+	}
+	if n.Identifier != "" {
+		comp["identifier"] = map[string]interface{}{
+			"system": "http://sys-ids.kemkes.go.id/composition/" + tenantID,
+			"value":  n.Identifier,
+		}
+	}
+	return comp
+}
+
+// CreateNoteComposition turns a free-text note for an encounter into a
+// sectioned Composition authored by the given practitioner and creates it.
+func CreateNoteComposition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req ClinicalNote
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+
+		var problems []string
+		if req.Kind == "" {
+			req.Kind = "progress"
+		}
+		kind, ok := noteKinds[req.Kind]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("kind %q must be progress, discharge or diet", req.Kind))
+		}
+		if req.PractitionerID == "" {
+			problems = append(problems, "practitioner_id is required")
+		}
+		sections := req.sections()
+		if len(sections) == 0 {
+			problems = append(problems, "note is empty")
+		}
+
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+		var patientID string
+		if req.EncounterID == "" {
+			problems = append(problems, "encounter_id is required")
+		} else {
+			id, found, err := encounterSubject(ctx, db, req.EncounterID)
+			switch {
+			case err != nil:
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to look up encounter"})
+			case !found:
+				problems = append(problems, fmt.Sprintf("encounter_id %s is not a known encounter of this tenant", req.EncounterID))
+			case id == "":
+				problems = append(problems, fmt.Sprintf("encounter %s names no patient", req.EncounterID))
+			}
+			patientID = id
+		}
+		if len(problems) > 0 {
+			return invalidResource(c, problems)
+		}
+
+		body, err := json.Marshal(req.composition(tenantID, patientID, kind, sections))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON"})
+		}
+		resp, err := ss.Create(ctx, compositionResource.Type, body)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+		if resp.OK() {
+			recordCreated(ctx, db, compositionResource, body, resp)
+		}
+		return c.JSONBlob(resp.StatusCode, resp.Body)
+	}
+}
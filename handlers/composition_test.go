@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClinicalNoteSections(t *testing.T) {
+	tests := []struct {
+		name string
+		note string
+		want []noteSection
+	}{
+		{"plain text", "Pasien stabil.\nLanjutkan terapi.",
+			[]noteSection{{Lines: []string{"Pasien stabil.", "Lanjutkan terapi."}}}},
+		{"SOAP on one line each", "S: nyeri kepala\r\nO: TD 120/80\nA: tension headache\nP: paracetamol",
+			[]noteSection{
+				{Heading: "S", Lines: []string{"nyeri kepala"}},
+				{Heading: "O", Lines: []string{"TD 120/80"}},
+				{Heading: "A", Lines: []string{"tension headache"}},
+				{Heading: "P", Lines: []string{"paracetamol"}},
+			}},
+		{"text before the first heading", "Kontrol rutin\n\nDiet:\n  rendah garam\n",
+			[]noteSection{{Lines: []string{"Kontrol rutin"}}, {Heading: "Diet", Lines: []string{"rendah garam"}}}},
+		{"short label alone on its line", "Riwayat alergi:\ntidak ada",
+			[]noteSection{{Heading: "Riwayat alergi", Lines: []string{"tidak ada"}}}},
+		{"colon inside a sentence", "Jam kontrol berikutnya adalah pukul: 10.00",
+			[]noteSection{{Lines: []string{"Jam kontrol berikutnya adalah pukul: 10.00"}}}},
+		{"empty headings are skipped", "Subjective:\nPlan:\nistirahat\nInstructions:",
+			[]noteSection{{Heading: "Plan", Lines: []string{"istirahat"}}}},
+		{"only headings", "Diet:\nPlan:", nil},
+		{"empty", "  \n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &ClinicalNote{Note: tt.note}
+			if got := n.sections(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("sections = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
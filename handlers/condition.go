@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+)
+
+// validateCondition checks a Condition (diagnosis) before it is sent: it
+// must be coded, name its patient and reference an Encounter the mirror
+// knows about.
+func validateCondition(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Condition" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Condition", rt))
+	}
+	if !hasCoding(resource, "code") {
+		problems = append(problems, "code.coding must hold at least one code (ICD-10)")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	return append(problems, checkEncounterReference(ctx, db, resource)...)
+}
+
+func CreateCondition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, conditionResource)
+}
+
+func UpdateCondition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, conditionResource)
+}
+
+func PatchCondition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, conditionResource)
+}
+
+func GetCondition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, conditionResource)
+}
+
+func SearchCondition(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, conditionResource)
+}
@@ -2,78 +2,383 @@ package handlers
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
 )
 
+// Credential is the API representation of utils.Credential. The client
+// secret is accepted in plaintext but only ever returned masked.
 type Credential struct {
-	ClientID     string `json:"client_id" bson:"client_id"`
-	ClientSecret string `json:"client_secret" bson:"client_secret"`
-	TokenURL     string `json:"token_url" bson:"token_url"`
+	ID string `json:"id,omitempty"`
+	// OrganizationID is the SatuSehat organization ID of the tenant.
+	OrganizationID string `json:"organization_id"`
+	Version        int    `json:"version,omitempty"`
+	Active         bool   `json:"active"`
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	TokenURL       string `json:"token_url,omitempty"`
+	// Environment names the SatuSehat profile (sandbox, staging, production)
+	// this credential belongs to; empty means the configured default.
+	Environment string `json:"environment"`
+
+	CreatedAt   time.Time  `json:"created_at,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at,omitempty"`
+	UpdatedBy   string     `json:"updated_by,omitempty"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
 }
 
-func DeleteCredential(db *mongo.Database) echo.HandlerFunc {
+func credentialView(cred *utils.Credential, keys *encryption.Keyring) Credential {
+	masked := "(unreadable)"
+	if secret, err := cred.Secret(keys); err == nil {
+		masked = utils.MaskSecret(secret)
+	}
+	return Credential{
+		ID:             cred.ID.Hex(),
+		OrganizationID: cred.OrganizationID,
+		Version:        cred.Version,
+		Active:         cred.Active,
+		ClientID:       cred.ClientID,
+		ClientSecret:   masked,
+		TokenURL:       cred.TokenURL,
+		Environment:    cred.Environment,
+		CreatedAt:      cred.CreatedAt,
+		CreatedBy:      cred.CreatedBy,
+		UpdatedAt:      cred.UpdatedAt,
+		UpdatedBy:      cred.UpdatedBy,
+		VerifiedAt:     cred.VerifiedAt,
+		ActivatedAt:    cred.ActivatedAt,
+	}
+}
+
+// auditCredential records a credential change. The secret is never logged.
+func auditCredential(ctx context.Context, db *mongo.Database, action string, cred *utils.Credential, statusCode int, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["version"] = cred.Version
+	details["client_id"] = cred.ClientID
+	details["environment"] = cred.Environment
+	_ = utils.LogAudit(ctx, db, models.AuditLog{
+		OrganizationID: cred.OrganizationID,
+		Action:         action,
+		Resource:       "credential",
+		ResourceID:     cred.ID.Hex(),
+		StatusCode:     statusCode,
+		Details:        details,
+	})
+}
+
+// credentialError maps a credential store error to an HTTP response.
+func credentialError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, utils.ErrCredentialNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Credential not found"})
+	case errors.Is(err, utils.ErrCredentialActive):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Cannot delete the active credential; activate another version first"})
+	case errors.Is(err, utils.ErrNoPreviousVersion):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Credential store error: " + err.Error()})
+}
+
+// tenantCredentialID reads the tenant and the :id credential path parameter.
+func tenantCredentialID(c echo.Context) (string, primitive.ObjectID, error) {
+	tenantID := utils.TenantFrom(c.Request().Context())
+	if tenantID == "" {
+		return "", primitive.NilObjectID, c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return "", primitive.NilObjectID, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid credential ID"})
+	}
+	return tenantID, id, nil
+}
+
+// verifyCredential performs a client-credentials exchange with cred. On
+// failure it returns the response body describing why.
+func verifyCredential(ctx context.Context, cfg *config.Config, cred utils.Credential, secret string) (map[string]interface{}, map[string]interface{}, error) {
+	profile, err := cfg.Profile(cred.Environment)
+	if err != nil {
+		return nil, nil, err
+	}
+	cred.ClientSecret = secret
+
+	tokenCtx, cancel := context.WithTimeout(ctx, cfg.SatuSehat.TokenTimeout)
+	res, err := utils.ExchangeToken(tokenCtx, cred, profile)
+	cancel()
+	if err != nil {
+		failure := map[string]interface{}{
+			"error":       "Credential verification failed",
+			"environment": profile.Name,
+			"detail":      err.Error(),
+		}
+		var oauthErr *utils.OAuthError
+		if errors.As(err, &oauthErr) {
+			failure["reason"] = oauthErr.Kind.Error()
+			failure["detail"] = oauthErr.Message
+			failure["upstream_status"] = oauthErr.StatusCode
+		}
+		return nil, failure, nil
+	}
+
+	return map[string]interface{}{
+		"environment":       profile.Name,
+		"expires_in":        int64(res.ExpiresIn.Seconds()),
+		"expires_at":        res.Expiry,
+		"organization_name": res.OrganizationName,
+		"application_name":  res.ApplicationName,
+	}, nil, nil
+}
+
+// ListCredential : every credential version of the tenant, newest first, secrets masked
+func ListCredential(db *mongo.Database, keys *encryption.Keyring) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
 
-		_, err := db.Collection("credentials").DeleteMany(ctx, bson.M{})
+		creds, err := utils.ListCredentials(ctx, db, tenantID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": fmt.Sprintf("Failed to delete credentials: %v", err),
-			})
+			return credentialError(c, err)
 		}
 
-		return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+		out := make([]Credential, 0, len(creds))
+		for i := range creds {
+			out = append(out, credentialView(&creds[i], keys))
+		}
+		return c.JSON(http.StatusOK, out)
 	}
 }
 
-// ListCredential : get current credential
-func ListCredential(db *mongo.Database) echo.HandlerFunc {
+func GetCredential(db *mongo.Database, keys *encryption.Keyring) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		var cred Credential
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		tenantID, id, err := tenantCredentialID(c)
+		if tenantID == "" {
+			return err
+		}
 
-		err := db.Collection("credentials").FindOne(ctx, bson.M{}).Decode(&cred)
+		cred, err := utils.FindCredential(c.Request().Context(), db, tenantID, id)
 		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				return c.JSON(http.StatusOK, nil) // Kalau tidak ada dokumen, kirimkan `null`
-			}
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": fmt.Sprintf("Failed to get credential: %v", err),
-			})
+			return credentialError(c, err)
 		}
-
-		return c.JSON(http.StatusOK, cred)
+		return c.JSON(http.StatusOK, credentialView(cred, keys))
 	}
 }
 
-func InsertCredential(db *mongo.Database) echo.HandlerFunc {
+// InsertCredential validates and stores a new credential version for the
+// tenant. It is activated right away with ?activate=true or when the tenant
+// has no active credential yet; otherwise it waits for an explicit activate,
+// so a rotation can be verified first. With ?verify=true the
+// client-credentials exchange must succeed before anything is stored.
+func InsertCredential(db *mongo.Database, cfg *config.Config, keys *encryption.Keyring, tokens *utils.TokenManager) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var cred Credential
 		if err := c.Bind(&cred); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+		if cred.OrganizationID == "" {
+			cred.OrganizationID = tenantID
+		} else if cred.OrganizationID != tenantID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "organization_id does not match the selected tenant"})
+		}
 
-		opts := options.Replace().SetUpsert(true)
-		_, err := db.Collection("credentials").ReplaceOne(ctx, bson.M{}, cred, opts)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": fmt.Sprintf("Failed to insert/update credential: %v", err),
+		stored := utils.Credential{
+			OrganizationID: cred.OrganizationID,
+			ClientID:       strings.TrimSpace(cred.ClientID),
+			TokenURL:       cred.TokenURL,
+			Environment:    cred.Environment,
+		}
+		if problems := stored.Validate(cfg, cred.ClientSecret); len(problems) > 0 {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{
+				"error":    "Invalid credential",
+				"problems": problems,
 			})
 		}
 
-		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+		var verification map[string]interface{}
+		if verify, _ := strconv.ParseBool(c.QueryParam("verify")); verify {
+			result, failure, err := verifyCredential(ctx, cfg, stored, cred.ClientSecret)
+			if err != nil {
+				return upstreamError(c, err)
+			}
+			if failure != nil {
+				// Nothing is stored; the working credential stays active
+				return c.JSON(http.StatusUnprocessableEntity, failure)
+			}
+			verification = result
+		}
+
+		if err := stored.SetSecret(keys, cred.ClientSecret); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to encrypt credential"})
+		}
+		if verification != nil {
+			now := time.Now()
+			stored.VerifiedAt = &now
+		}
+
+		if err := utils.InsertCredentialVersion(ctx, db, &stored, utils.UserFrom(ctx)); err != nil {
+			return credentialError(c, err)
+		}
+		auditCredential(ctx, db, "create", &stored, http.StatusCreated, map[string]interface{}{"verified": verification != nil})
+
+		activate, _ := strconv.ParseBool(c.QueryParam("activate"))
+		if !activate {
+			if _, err := utils.ActiveCredential(ctx, db, tenantID); errors.Is(err, utils.ErrCredentialNotFound) {
+				activate = true
+			}
+		}
+		if activate {
+			activated, err := utils.ActivateCredential(ctx, db, tenantID, stored.ID, utils.UserFrom(ctx))
+			if err != nil {
+				return credentialError(c, err)
+			}
+			stored = *activated
+			// Tokens issued for the previous credential must not be reused
+			_ = tokens.Invalidate(ctx, tenantID)
+			auditCredential(ctx, db, "activate", &stored, http.StatusOK, nil)
+		}
+
+		resp := map[string]interface{}{
+			"status":     "ok",
+			"credential": credentialView(&stored, keys),
+		}
+		if verification != nil {
+			resp["verified"] = true
+			resp["verification"] = verification
+		}
+		return c.JSON(http.StatusCreated, resp)
+	}
+}
+
+// VerifyCredential runs the client-credentials exchange for a stored
+// credential version without activating it.
+func VerifyCredential(db *mongo.Database, cfg *config.Config, keys *encryption.Keyring) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tenantID, id, err := tenantCredentialID(c)
+		if tenantID == "" {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		cred, err := utils.FindCredential(ctx, db, tenantID, id)
+		if err != nil {
+			return credentialError(c, err)
+		}
+		secret, err := cred.Secret(keys)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decrypt credential"})
+		}
+
+		verification, failure, err := verifyCredential(ctx, cfg, *cred, secret)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+		if failure != nil {
+			auditCredential(ctx, db, "verify", cred, http.StatusUnprocessableEntity, map[string]interface{}{"error": failure["detail"]})
+			return c.JSON(http.StatusUnprocessableEntity, failure)
+		}
+
+		if err := utils.MarkCredentialVerified(ctx, db, cred, utils.UserFrom(ctx)); err != nil {
+			return credentialError(c, err)
+		}
+		auditCredential(ctx, db, "verify", cred, http.StatusOK, nil)
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"verified":     true,
+			"verification": verification,
+			"credential":   credentialView(cred, keys),
+		})
+	}
+}
+
+// ActivateCredential makes a credential version the one used for tokens.
+func ActivateCredential(db *mongo.Database, keys *encryption.Keyring, tokens *utils.TokenManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tenantID, id, err := tenantCredentialID(c)
+		if tenantID == "" {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		cred, err := utils.ActivateCredential(ctx, db, tenantID, id, utils.UserFrom(ctx))
+		if err != nil {
+			return credentialError(c, err)
+		}
+		_ = tokens.Invalidate(ctx, tenantID)
+		auditCredential(ctx, db, "activate", cred, http.StatusOK, nil)
+
+		return c.JSON(http.StatusOK, credentialView(cred, keys))
+	}
+}
+
+// RollbackCredential re-activates the credential that was active before the
+// current one.
+func RollbackCredential(db *mongo.Database, keys *encryption.Keyring, tokens *utils.TokenManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		tenantID := utils.TenantFrom(ctx)
+		if tenantID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+
+		previous, err := utils.PreviousCredential(ctx, db, tenantID)
+		if err != nil {
+			return credentialError(c, err)
+		}
+		var from interface{}
+		if current, err := utils.ActiveCredential(ctx, db, tenantID); err == nil {
+			from = current.ID.Hex()
+		}
+
+		cred, err := utils.ActivateCredential(ctx, db, tenantID, previous.ID, utils.UserFrom(ctx))
+		if err != nil {
+			return credentialError(c, err)
+		}
+		_ = tokens.Invalidate(ctx, tenantID)
+		auditCredential(ctx, db, "rollback", cred, http.StatusOK, map[string]interface{}{"from": from})
+
+		return c.JSON(http.StatusOK, credentialView(cred, keys))
+	}
+}
+
+// DeleteCredential removes an inactive credential version. The active one
+// cannot be deleted.
+func DeleteCredential(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tenantID, id, err := tenantCredentialID(c)
+		if tenantID == "" {
+			return err
+		}
+
+		ctx := c.Request().Context()
+		cred, err := utils.DeleteCredentialVersion(ctx, db, tenantID, id)
+		if err != nil {
+			return credentialError(c, err)
+		}
+		auditCredential(ctx, db, "delete", cred, http.StatusOK, nil)
+
+		return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
 	}
 }
@@ -1,275 +1,75 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
-	"time"
 
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"satusehat-golang/models"
-	"satusehat-golang/utils"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
 )
 
-func CreateEncounter(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		body, err := io.ReadAll(c.Request().Body)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read body"})
-		}
-		c.Request().Body = io.NopCloser(bytes.NewReader(body))
-
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to get token"})
-		}
-
-		req, _ := http.NewRequest("POST", "https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Encounter", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to post to satusehat"})
-		}
-		defer resp.Body.Close()
-
-		respBody, _ := io.ReadAll(resp.Body)
-
-		var resourceID string
-		if resp.StatusCode == 200 || resp.StatusCode == 201 {
-			// Unmarshal response to extract ID
-			var encResp models.EncounterResponse
-			if err := json.Unmarshal(respBody, &encResp); err == nil {
-				resourceID = encResp.ID
-				// Insert into MongoDB
-				var doc interface{}
-				if err := json.Unmarshal(respBody, &doc); err == nil {
-					_, _ = db.Collection("encounters").InsertOne(context.Background(), doc)
-				}
-			}
+// staffReferencePrefix marks a participant reference that names a doctor by
+// the hospital's staff code instead of the IHS practitioner ID.
+const staffReferencePrefix = "Staff/"
 
-			// Save to audit log with ResourceID
-			_ = utils.LogAudit(db, models.AuditLog{
-				User:       "Admin", // Replace with JWT data if available
-				Action:     "create",
-				Resource:   "encounter",
-				ResourceID: resourceID, // <-- use extracted ID here
-				StatusCode: resp.StatusCode,
-				Details: map[string]interface{}{
-					"requestBody":  json.RawMessage(body),
-					"responseBody": json.RawMessage(respBody),
-				},
-			})
-		}
-
-		return c.JSONBlob(resp.StatusCode, respBody)
-	}
+func CreateEncounter(db *mongo.Database, ss *satusehat.Client, roster *utils.Roster) echo.HandlerFunc {
+	return createResource(db, ss, encounterWithRoster(roster))
 }
 
-func UpdateEncounter(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		encounterID := c.Param("id")
-		if encounterID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing encounter ID"})
-		}
-
-		var encounter map[string]interface{}
-		if err := c.Bind(&encounter); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
-		}
-
-		reqBody, err := json.Marshal(encounter)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON"})
-		}
-
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
-		}
-
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Encounter/%s", encounterID)
-
-		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(reqBody))
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
-		}
-
-		req.Header.Set("Content-Type", "application/fhir+json")
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
-		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-
-		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
-			// Save to audit log
-			_ = utils.LogAudit(db, models.AuditLog{
-				User:       "Admin",
-				Action:     "put",
-				Resource:   "encounter",
-				ResourceID: encounterID,
-				StatusCode: resp.StatusCode,
-				Details: map[string]interface{}{
-					"requestBody":  json.RawMessage(reqBody),
-					"responseBody": json.RawMessage(body),
-				},
-			})
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			filter := bson.M{"id": encounterID}
-			update := bson.M{"$set": encounter}
-			_, err := db.Collection("encounters").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
-			if err != nil {
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to mirror to MongoDB"})
-			}
-		}
-
-		return c.Blob(resp.StatusCode, "application/fhir+json", body)
-	}
+func UpdateEncounter(db *mongo.Database, ss *satusehat.Client, roster *utils.Roster) echo.HandlerFunc {
+	return updateResource(db, ss, encounterWithRoster(roster))
 }
 
-func PatchEncounter(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		encounterID := c.Param("id")
-		if encounterID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing encounter ID"})
-		}
-
-		// Read JSON Patch operations
-		var patchOps []map[string]interface{}
-		if err := c.Bind(&patchOps); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
-		}
-
-		reqBody, err := json.Marshal(patchOps)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON patch"})
-		}
-
-		// Get token
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
-		}
-
-		// Build PATCH request
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Encounter/%s", encounterID)
-		req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(reqBody))
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
-		}
-
-		req.Header.Set("Content-Type", "application/json-patch+json")
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
-		}
-		defer resp.Body.Close()
-
-		respBody, _ := io.ReadAll(resp.Body)
-
-		// Audit log (record both request and response bodies)
-		_ = utils.LogAudit(db, models.AuditLog{
-			User:       "Admin", // Extract from auth context if available
-			Action:     "patch",
-			Resource:   "encounter",
-			ResourceID: encounterID,
-			StatusCode: resp.StatusCode,
-			Details: map[string]interface{}{
-				"requestBody":  json.RawMessage(reqBody),
-				"responseBody": json.RawMessage(respBody),
-			},
-		})
-
-		// If successful, mirror updated fields to MongoDB
-		if resp.StatusCode == http.StatusOK {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+func PatchEncounter(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, encounterResource)
+}
 
-			update := bson.M{}
-			for _, op := range patchOps {
-				if op["op"] == "replace" && op["path"] != nil && op["value"] != nil {
-					path := strings.TrimPrefix(op["path"].(string), "/")
-					update[path] = op["value"]
-				}
-			}
-			if len(update) > 0 {
-				filter := bson.M{"id": encounterID}
-				_, err := db.Collection("encounters").UpdateOne(ctx, filter, bson.M{"$set": update})
-				if err != nil {
-					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to mirror to MongoDB"})
-				}
-			}
-		}
+func GetEncounter(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, encounterResource)
+}
 
-		// Return SatuSehat response
-		return c.JSONBlob(resp.StatusCode, respBody)
+func encounterWithRoster(roster *utils.Roster) fhirResource {
+	r := encounterResource
+	r.Prepare = func(ctx context.Context, _ *mongo.Database, resource map[string]interface{}) []string {
+		return resolveStaffReferences(ctx, roster, resource)
 	}
+	return r
 }
 
-func GetEncounter(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		encounterID := c.Param("id")
-		if encounterID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing encounter ID"})
-		}
-
-		// Example: get token for SatuSehat API
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
-		}
-
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Encounter/%s", encounterID)
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
-		}
-
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
+// resolveStaffReferences rewrites every participant[].individual reference
+// of the form "Staff/<staff code>" to "Practitioner/<IHS ID>" using the
+// practitioner roster, and fills in the display name if it is missing.
+func resolveStaffReferences(ctx context.Context, roster *utils.Roster, resource map[string]interface{}) []string {
+	participants, _ := resource["participant"].([]interface{})
+	var problems []string
+	for i, item := range participants {
+		participant, _ := item.(map[string]interface{})
+		individual, _ := participant["individual"].(map[string]interface{})
+		ref, _ := individual["reference"].(string)
+		staffID, ok := strings.CutPrefix(ref, staffReferencePrefix)
+		if !ok {
+			continue
+		}
+
+		entry, err := roster.Resolve(ctx, staffID)
+		switch {
+		case errors.Is(err, utils.ErrStaffNotFound):
+			problems = append(problems, fmt.Sprintf("participant[%d].individual.reference: staff code %q is not in the practitioner roster", i, staffID))
+			continue
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("participant[%d].individual.reference: no SatuSehat practitioner found for staff code %q: %v", i, staffID, err))
+			continue
+		}
+
+		individual["reference"] = "Practitioner/" + entry.IHSID
+		if _, ok := individual["display"]; !ok && entry.Name != "" {
+			individual["display"] = entry.Name
 		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-
-		// Log audit for the GET request
-		_ = utils.LogAudit(db, models.AuditLog{
-			User:       "Admin", // Extract from JWT/auth context in real app
-			Action:     "get",
-			Resource:   "encounter",
-			ResourceID: encounterID,
-			StatusCode: resp.StatusCode,
-			Details: map[string]interface{}{
-				"queryParams": c.QueryParams(),
-				"response":    json.RawMessage(body),
-			},
-		})
-
-		return c.JSONBlob(resp.StatusCode, body)
 	}
+	return problems
 }
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// fhirResource describes how one FHIR resource type is proxied to SatuSehat,
+// mirrored into MongoDB and recorded in the audit log.
+type fhirResource struct {
+	Type       string // FHIR resource type, e.g. "Encounter"
+	Collection string // mirror collection; empty means the resource is not mirrored
+	Audit      string // AuditLog.Resource value
+	// Prepare, if set, rewrites a resource before it is validated and sent,
+	// e.g. to resolve local codes to SatuSehat IDs. It returns every problem
+	// that kept it from doing so.
+	Prepare func(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string
+	// Validate, if set, checks a resource before it is created or updated
+	// and returns every problem found.
+	Validate func(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string
+	// ValidatePatch, if set, checks JSON Patch operations before they are
+	// sent.
+	ValidatePatch func(ops []map[string]interface{}) []string
+	// Mirrored, if set, runs after a created, updated or patched resource has
+	// been written to Collection, to maintain links to other mirrored
+	// resources. It gets the whole resource as mirrored.
+	Mirrored func(ctx context.Context, db *mongo.Database, id string, resource map[string]interface{})
+}
+
+var (
+	encounterResource    = fhirResource{Type: "Encounter", Collection: "encounters", Audit: "encounter"}
+	locationResource     = fhirResource{Type: "Location", Collection: "locations", Audit: "location"}
+	patientResource      = fhirResource{Type: "Patient", Audit: "Patient"}
+	practitionerResource = fhirResource{Type: "Practitioner", Audit: "Practitioner"}
+	conditionResource    = fhirResource{Type: "Condition", Collection: "conditions", Audit: "condition", Validate: validateCondition}
+	observationResource  = fhirResource{Type: "Observation", Collection: "observations", Audit: "observation", Validate: validateObservation}
+	procedureResource    = fhirResource{Type: "Procedure", Collection: "procedures", Audit: "procedure", Validate: validateProcedure}
+	medicationResource   = fhirResource{Type: "Medication", Collection: "medications", Audit: "medication", Validate: validateMedication}
+	compositionResource  = fhirResource{Type: "Composition", Collection: "compositions", Audit: "composition", Validate: validateComposition}
+	immunizationResource = fhirResource{Type: "Immunization", Collection: "immunizations", Audit: "immunization", Validate: validateImmunization}
+	organizationResource = fhirResource{Type: "Organization", Collection: "organizations", Audit: "organization", Validate: validateOrganization}
+
+	serviceRequestResource   = fhirResource{Type: "ServiceRequest", Collection: "service_requests", Audit: "service_request", Validate: validateServiceRequest}
+	specimenResource         = fhirResource{Type: "Specimen", Collection: "specimens", Audit: "specimen", Validate: validateSpecimen}
+	diagnosticReportResource = fhirResource{Type: "DiagnosticReport", Collection: "diagnostic_reports", Audit: "diagnostic_report", Validate: validateDiagnosticReport}
+
+	allergyIntoleranceResource = fhirResource{
+		Type:          "AllergyIntolerance",
+		Collection:    "allergy_intolerances",
+		Audit:         "allergy_intolerance",
+		Validate:      validateAllergyIntolerance,
+		ValidatePatch: validateAllergyIntolerancePatch,
+	}
+
+	medicationRequestResource = fhirResource{
+		Type:       "MedicationRequest",
+		Collection: "medication_requests",
+		Audit:      "medication_request",
+		Validate:   validateMedicationRequest,
+	}
+	medicationDispenseResource = fhirResource{
+		Type:       "MedicationDispense",
+		Collection: "medication_dispenses",
+		Audit:      "medication_dispense",
+		Validate:   validateMedicationDispense,
+		Mirrored:   linkDispense,
+	}
+)
+
+// invalidResource answers 400 with an OperationOutcome listing problems.
+func invalidResource(c echo.Context, problems []string) error {
+	outcome := models.OperationOutcome{ResourceType: "OperationOutcome"}
+	for _, p := range problems {
+		outcome.Issue = append(outcome.Issue, models.OperationOutcomeIssue{Severity: "error", Code: "invalid", Diagnostics: p})
+	}
+	return c.JSON(http.StatusBadRequest, outcome)
+}
+
+// upstreamError maps a failed SatuSehat call to an HTTP response. Token
+// failures carry the message from the OAuth endpoint so callers can tell a
+// bad secret from an outage.
+func upstreamError(c echo.Context, err error) error {
+	if errors.Is(err, config.ErrProductionNotAllowed) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, utils.ErrNoTenant) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var cooldown *utils.TokenCooldownError
+	if errors.As(err, &cooldown) {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(time.Until(cooldown.Until).Seconds())+1))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error":  "Failed to get token",
+			"detail": err.Error(),
+		})
+	}
+
+	var oauthErr *utils.OAuthError
+	if errors.As(err, &oauthErr) {
+		return c.JSON(http.StatusBadGateway, map[string]string{
+			"error":  "Failed to get token",
+			"reason": oauthErr.Kind.Error(),
+			"detail": oauthErr.Message,
+		})
+	}
+
+	var epErr *satusehat.EndpointError
+	if errors.As(err, &epErr) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
+}
+
+func createResource(db *mongo.Database, ss *satusehat.Client, r fhirResource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read body"})
+		}
+
+		ctx := c.Request().Context()
+		if r.Prepare != nil || r.Validate != nil {
+			var resource map[string]interface{}
+			if err := json.Unmarshal(body, &resource); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+			}
+			if r.Prepare != nil {
+				if problems := r.Prepare(ctx, db, resource); len(problems) > 0 {
+					return invalidResource(c, problems)
+				}
+				if body, err = json.Marshal(resource); err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON"})
+				}
+			}
+			if r.Validate != nil {
+				if problems := r.Validate(ctx, db, resource); len(problems) > 0 {
+					return invalidResource(c, problems)
+				}
+			}
+		}
+
+		resp, err := ss.Create(ctx, r.Type, body)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+
+		if resp.OK() {
+			recordCreated(ctx, db, r, body, resp)
+		}
+
+		return c.JSONBlob(resp.StatusCode, resp.Body)
+	}
+}
+
+// recordCreated mirrors a resource SatuSehat accepted into r.Collection,
+// tagged with the tenant, and writes the create audit entry. It returns the
+// ID SatuSehat assigned.
+func recordCreated(ctx context.Context, db *mongo.Database, r fhirResource, body []byte, resp *satusehat.Response) string {
+	resourceID := resp.ID()
+	if resourceID != "" && r.Collection != "" {
+		// Insert into MongoDB, tagged with the tenant
+		var doc map[string]interface{}
+		if err := json.Unmarshal(resp.Body, &doc); err == nil {
+			doc["organization_id"] = utils.TenantFrom(ctx)
+			_, err := db.Collection(r.Collection).InsertOne(context.WithoutCancel(ctx), doc)
+			if err == nil && r.Mirrored != nil {
+				r.Mirrored(context.WithoutCancel(ctx), db, resourceID, doc)
+			}
+		}
+	}
+
+	// Save to audit log with ResourceID
+	_ = utils.LogAudit(ctx, db, models.AuditLog{
+		Action:     "create",
+		Resource:   r.Audit,
+		ResourceID: resourceID,
+		StatusCode: resp.StatusCode,
+		Details: map[string]interface{}{
+			"requestBody":  json.RawMessage(body),
+			"responseBody": json.RawMessage(resp.Body),
+		},
+	})
+	return resourceID
+}
+
+func updateResource(db *mongo.Database, ss *satusehat.Client, r fhirResource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		resourceID := c.Param("id")
+		if resourceID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Missing %s ID", r.Audit)})
+		}
+
+		var resource map[string]interface{}
+		if err := c.Bind(&resource); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+
+		ctx := c.Request().Context()
+		if r.Prepare != nil {
+			if problems := r.Prepare(ctx, db, resource); len(problems) > 0 {
+				return invalidResource(c, problems)
+			}
+		}
+
+		reqBody, err := json.Marshal(resource)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON"})
+		}
+
+		if r.Validate != nil {
+			if problems := r.Validate(ctx, db, resource); len(problems) > 0 {
+				return invalidResource(c, problems)
+			}
+		}
+
+		resp, err := ss.Update(ctx, r.Type, resourceID, reqBody)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+
+		if resp.OK() {
+			// Save to audit log
+			_ = utils.LogAudit(ctx, db, models.AuditLog{
+				Action:     "put",
+				Resource:   r.Audit,
+				ResourceID: resourceID,
+				StatusCode: resp.StatusCode,
+				Details: map[string]interface{}{
+					"requestBody":  json.RawMessage(reqBody),
+					"responseBody": json.RawMessage(resp.Body),
+				},
+			})
+
+			if r.Collection != "" {
+				// SatuSehat already accepted the change, so mirror it even if the caller has gone away
+				// The tenant and IDs come from the request, never from the body,
+				// so a body cannot move the mirrored document to another tenant
+				tenantID := utils.TenantFrom(ctx)
+				delete(resource, "_id")
+				resource["id"] = resourceID
+				resource["organization_id"] = tenantID
+				filter := bson.M{"id": resourceID, "organization_id": tenantID}
+				update := bson.M{"$set": resource}
+				_, err := db.Collection(r.Collection).UpdateOne(context.WithoutCancel(ctx), filter, update, options.Update().SetUpsert(true))
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to mirror to MongoDB"})
+				}
+				if r.Mirrored != nil {
+					r.Mirrored(context.WithoutCancel(ctx), db, resourceID, resource)
+				}
+			}
+		}
+
+		return c.Blob(resp.StatusCode, "application/fhir+json", resp.Body)
+	}
+}
+
+func patchResource(db *mongo.Database, ss *satusehat.Client, r fhirResource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		resourceID := c.Param("id")
+		if resourceID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Missing %s ID", r.Audit)})
+		}
+
+		// Read JSON Patch operations
+		var patchOps []map[string]interface{}
+		if err := c.Bind(&patchOps); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+		if r.ValidatePatch != nil {
+			if problems := r.ValidatePatch(patchOps); len(problems) > 0 {
+				return invalidResource(c, problems)
+			}
+		}
+
+		reqBody, err := json.Marshal(patchOps)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON patch"})
+		}
+
+		ctx := c.Request().Context()
+		resp, err := ss.Patch(ctx, r.Type, resourceID, reqBody)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+
+		// Audit log (record both request and response bodies)
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "patch",
+			Resource:   r.Audit,
+			ResourceID: resourceID,
+			StatusCode: resp.StatusCode,
+			Details: map[string]interface{}{
+				"requestBody":  json.RawMessage(reqBody),
+				"responseBody": json.RawMessage(resp.Body),
+			},
+		})
+
+		// If successful, mirror updated fields to MongoDB
+		if resp.StatusCode == http.StatusOK && r.Collection != "" {
+			update := bson.M{}
+			for _, op := range patchOps {
+				if op["op"] == "replace" && op["path"] != nil && op["value"] != nil {
+					path, _ := op["path"].(string)
+					switch field := strings.TrimPrefix(path, "/"); field {
+					case "_id", "id", "organization_id":
+						// not the caller's to change in the mirror
+					default:
+						update[field] = op["value"]
+					}
+				}
+			}
+			if len(update) > 0 {
+				filter := bson.M{"id": resourceID, "organization_id": utils.TenantFrom(ctx)}
+				_, err := db.Collection(r.Collection).UpdateOne(context.WithoutCancel(ctx), filter, bson.M{"$set": update})
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to mirror to MongoDB"})
+				}
+				if r.Mirrored != nil {
+					doc, err := findOneMirrored(context.WithoutCancel(ctx), db, r.Collection, resourceID)
+					if err == nil && doc != nil {
+						r.Mirrored(context.WithoutCancel(ctx), db, resourceID, doc)
+					}
+				}
+			}
+		}
+
+		// Return SatuSehat response
+		return c.JSONBlob(resp.StatusCode, resp.Body)
+	}
+}
+
+func getResource(db *mongo.Database, ss *satusehat.Client, r fhirResource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		resourceID := c.Param("id")
+		if resourceID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Missing %s ID", r.Audit)})
+		}
+
+		ctx := c.Request().Context()
+		resp, err := ss.Read(ctx, r.Type, resourceID)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+
+		// Log audit for the GET request
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "get",
+			Resource:   r.Audit,
+			ResourceID: resourceID,
+			StatusCode: resp.StatusCode,
+			Details: map[string]interface{}{
+				"queryParams": c.QueryParams(),
+				"response":    json.RawMessage(resp.Body),
+			},
+		})
+
+		return c.JSONBlob(resp.StatusCode, resp.Body)
+	}
+}
+
+// searchResource passes the query string to a SatuSehat search and returns
+// the Bundle.
+func searchResource(db *mongo.Database, ss *satusehat.Client, r fhirResource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		query := c.QueryParams()
+		if len(query) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("At least one %s search parameter is required", r.Type)})
+		}
+
+		ctx := c.Request().Context()
+		resp, err := ss.Search(ctx, r.Type, query)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "search",
+			Resource:   r.Audit,
+			StatusCode: resp.StatusCode,
+			Details: map[string]interface{}{
+				"queryParams": query,
+			},
+		})
+
+		return c.JSONBlob(resp.StatusCode, resp.Body)
+	}
+}
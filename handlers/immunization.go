@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+var immunizationStatuses = map[string]bool{"completed": true, "entered-in-error": true, "not-done": true}
+
+// validateImmunization checks a vaccination before it is sent.
+func validateImmunization(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Immunization" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Immunization", rt))
+	}
+	if status, _ := resource["status"].(string); !immunizationStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q must be completed, entered-in-error or not-done", status))
+	}
+	if !hasCoding(resource, "vaccineCode") {
+		problems = append(problems, "vaccineCode.coding must hold at least one code")
+	}
+	_, hasDateTime := resource["occurrenceDateTime"].(string)
+	_, hasString := resource["occurrenceString"].(string)
+	if !hasDateTime && !hasString {
+		problems = append(problems, "occurrenceDateTime or occurrenceString is required")
+	}
+	if _, problem := referenceID(resource, "patient", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	if _, ok := resource["encounter"]; ok {
+		problems = append(problems, checkEncounterReference(ctx, db, resource)...)
+	}
+	return problems
+}
+
+func CreateImmunization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, immunizationResource)
+}
+
+func UpdateImmunization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, immunizationResource)
+}
+
+func PatchImmunization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, immunizationResource)
+}
+
+func GetImmunization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, immunizationResource)
+}
+
+func SearchImmunization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, immunizationResource)
+}
+
+// firstCoding returns the first coding of resource[field].
+func firstCoding(resource map[string]interface{}, field string) map[string]interface{} {
+	concept, _ := resource[field].(map[string]interface{})
+	codings, _ := concept["coding"].([]interface{})
+	if len(codings) == 0 {
+		return nil
+	}
+	coding, _ := codings[0].(map[string]interface{})
+	return coding
+}
+
+// occurrenceLayouts are the forms of a FHIR dateTime, most precise first.
+var occurrenceLayouts = []string{time.RFC3339Nano, "2006-01-02", "2006-01", "2006"}
+
+// occurrenceTime parses an occurrenceDateTime.
+func occurrenceTime(value string) (time.Time, bool) {
+	for _, layout := range occurrenceLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// datedEntry is a history entry with the time it occurred, if known.
+type datedEntry struct {
+	entry      map[string]interface{}
+	occurredAt time.Time
+	dated      bool
+}
+
+// sortByOccurrence orders history entries newest first by their
+// occurrenceDateTime, compared as instants so timezone offsets do not
+// matter. Entries with only an occurrenceString (or no parseable date) go
+// last, ordered by that text and then by ID.
+func sortByOccurrence(entries []datedEntry) []map[string]interface{} {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.dated != b.dated {
+			return a.dated
+		}
+		if a.dated && !a.occurredAt.Equal(b.occurredAt) {
+			return a.occurredAt.After(b.occurredAt)
+		}
+		oa, _ := a.entry["occurrence"].(string)
+		ob, _ := b.entry["occurrence"].(string)
+		if oa != ob {
+			return oa < ob
+		}
+		ia, _ := a.entry["id"].(string)
+		ib, _ := b.entry["id"].(string)
+		return ia < ib
+	})
+	history := make([]map[string]interface{}, len(entries))
+	for i, e := range entries {
+		history[i] = e.entry
+	}
+	return history
+}
+
+// PatientImmunizations returns a patient's vaccination history, newest
+// first, from the immunizations mirror. Entries marked entered-in-error
+// are left out.
+func PatientImmunizations(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		patientID := c.Param("id")
+
+		docs, err := findMirrored(ctx, db, immunizationResource.Collection, bson.M{
+			"patient.reference": "Patient/" + patientID,
+			"status":            bson.M{"$ne": "entered-in-error"},
+		})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch immunizations"})
+		}
+
+		entries := make([]datedEntry, 0, len(docs))
+		for _, doc := range docs {
+			occurrence, _ := doc["occurrenceDateTime"].(string)
+			occurredAt, dated := occurrenceTime(occurrence)
+			if occurrence == "" {
+				occurrence, _ = doc["occurrenceString"].(string)
+			}
+			entry := map[string]interface{}{
+				"id":         doc["id"],
+				"status":     doc["status"],
+				"vaccine":    firstCoding(doc, "vaccineCode"),
+				"occurrence": occurrence,
+				"lotNumber":  doc["lotNumber"],
+				"resource":   doc,
+			}
+			if protocols, _ := doc["protocolApplied"].([]interface{}); len(protocols) > 0 {
+				protocol, _ := protocols[0].(map[string]interface{})
+				entry["doseNumber"] = protocol["doseNumberPositiveInt"]
+			}
+			entries = append(entries, datedEntry{entry: entry, occurredAt: occurredAt, dated: dated})
+		}
+		history := sortByOccurrence(entries)
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "list",
+			Resource:   immunizationResource.Audit,
+			ResourceID: patientID,
+			StatusCode: http.StatusOK,
+		})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"patient":       "Patient/" + patientID,
+			"immunizations": history,
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortByOccurrence(t *testing.T) {
+	entry := func(id, occurrence string) datedEntry {
+		at, dated := occurrenceTime(occurrence)
+		return datedEntry{entry: map[string]interface{}{"id": id, "occurrence": occurrence}, occurredAt: at, dated: dated}
+	}
+	entries := []datedEntry{
+		entry("text-b", "masa kanak-kanak"),
+		entry("jakarta", "2024-01-01T08:00:00+07:00"), // 01:00 UTC
+		entry("utc", "2024-01-01T02:00:00Z"),
+		entry("year", "2023"),
+		entry("text-a", "dewasa"),
+		entry("day", "2024-01-01"),
+		entry("text-a2", "dewasa"),
+	}
+
+	var got []string
+	for _, e := range sortByOccurrence(entries) {
+		got = append(got, e["id"].(string))
+	}
+	want := []string{"utc", "jakarta", "day", "year", "text-a", "text-a2", "text-b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// FHIR value sets of the laboratory and radiology workflow.
+var (
+	serviceRequestStatuses = map[string]bool{
+		"draft": true, "active": true, "on-hold": true, "revoked": true,
+		"completed": true, "entered-in-error": true, "unknown": true,
+	}
+	serviceRequestIntents = map[string]bool{
+		"proposal": true, "plan": true, "directive": true, "order": true, "original-order": true,
+		"reflex-order": true, "filler-order": true, "instance-order": true, "option": true,
+	}
+	specimenStatuses = map[string]bool{
+		"available": true, "unavailable": true, "unsatisfactory": true, "entered-in-error": true,
+	}
+	diagnosticReportStatuses = map[string]bool{
+		"registered": true, "partial": true, "preliminary": true, "final": true, "amended": true,
+		"corrected": true, "appended": true, "cancelled": true, "entered-in-error": true, "unknown": true,
+	}
+)
+
+// checkMirroredReferences checks that every ID is in the tenant's mirror
+// collection.
+func checkMirroredReferences(ctx context.Context, db *mongo.Database, field, collection, resourceType string, ids []string) []string {
+	var problems []string
+	for _, id := range ids {
+		ok, err := mirrored(ctx, db, collection, id)
+		if err != nil {
+			return append(problems, fmt.Sprintf("could not check %s: %v", field, err))
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s %s/%s is not known to the mirror of this tenant", field, resourceType, id))
+		}
+	}
+	return problems
+}
+
+// validateServiceRequest checks a laboratory or radiology order.
+func validateServiceRequest(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "ServiceRequest" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be ServiceRequest", rt))
+	}
+	if status, _ := resource["status"].(string); !serviceRequestStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not a service request status", status))
+	}
+	if intent, _ := resource["intent"].(string); !serviceRequestIntents[intent] {
+		problems = append(problems, fmt.Sprintf("intent %q is not a service request intent", intent))
+	}
+	if !hasCoding(resource, "code") {
+		problems = append(problems, "code.coding must hold at least one code (LOINC)")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	return append(problems, checkEncounterReference(ctx, db, resource)...)
+}
+
+// validateSpecimen checks a collected sample; it must name the order it
+// was collected for.
+func validateSpecimen(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Specimen" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Specimen", rt))
+	}
+	if status, ok := resource["status"].(string); ok && !specimenStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not a specimen status", status))
+	}
+	if !hasCoding(resource, "type") {
+		problems = append(problems, "type.coding must hold at least one code")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	requests, refProblems := referenceIDs(resource, "request", "ServiceRequest")
+	problems = append(problems, refProblems...)
+	if len(requests) == 0 && len(refProblems) == 0 {
+		problems = append(problems, "request must name the ServiceRequest the specimen was collected for")
+	}
+	return append(problems, checkMirroredReferences(ctx, db, "request", "service_requests", "ServiceRequest", requests)...)
+}
+
+// validateDiagnosticReport checks a result report; it must be based on an
+// order and may link the specimens and result Observations it covers.
+func validateDiagnosticReport(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "DiagnosticReport" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be DiagnosticReport", rt))
+	}
+	if status, _ := resource["status"].(string); !diagnosticReportStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not a diagnostic report status", status))
+	}
+	if !hasCoding(resource, "code") {
+		problems = append(problems, "code.coding must hold at least one code (LOINC)")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	if _, ok := resource["encounter"]; ok {
+		problems = append(problems, checkEncounterReference(ctx, db, resource)...)
+	}
+
+	basedOn, refProblems := referenceIDs(resource, "basedOn", "ServiceRequest")
+	problems = append(problems, refProblems...)
+	if len(basedOn) == 0 && len(refProblems) == 0 {
+		problems = append(problems, "basedOn must name the ServiceRequest being reported")
+	}
+	problems = append(problems, checkMirroredReferences(ctx, db, "basedOn", "service_requests", "ServiceRequest", basedOn)...)
+
+	specimens, refProblems := referenceIDs(resource, "specimen", "Specimen")
+	problems = append(problems, refProblems...)
+	problems = append(problems, checkMirroredReferences(ctx, db, "specimen", "specimens", "Specimen", specimens)...)
+
+	results, refProblems := referenceIDs(resource, "result", "Observation")
+	problems = append(problems, refProblems...)
+	return append(problems, checkMirroredReferences(ctx, db, "result", "observations", "Observation", results)...)
+}
+
+func CreateServiceRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, serviceRequestResource)
+}
+
+func UpdateServiceRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, serviceRequestResource)
+}
+
+func PatchServiceRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, serviceRequestResource)
+}
+
+func GetServiceRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, serviceRequestResource)
+}
+
+func SearchServiceRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, serviceRequestResource)
+}
+
+func CreateSpecimen(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, specimenResource)
+}
+
+func UpdateSpecimen(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, specimenResource)
+}
+
+func PatchSpecimen(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, specimenResource)
+}
+
+func GetSpecimen(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, specimenResource)
+}
+
+func SearchSpecimen(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, specimenResource)
+}
+
+func CreateDiagnosticReport(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, diagnosticReportResource)
+}
+
+func UpdateDiagnosticReport(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, diagnosticReportResource)
+}
+
+func PatchDiagnosticReport(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, diagnosticReportResource)
+}
+
+func GetDiagnosticReport(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, diagnosticReportResource)
+}
+
+func SearchDiagnosticReport(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, diagnosticReportResource)
+}
+
+// ServiceRequestReport assembles an order with its specimens, reports and
+// the reports' result Observations from the mirror.
+func ServiceRequestReport(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		id := c.Param("id")
+
+		order, err := findOneMirrored(ctx, db, serviceRequestResource.Collection, id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch service request"})
+		}
+		if order == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Service request not found in the mirror"})
+		}
+
+		ref := "ServiceRequest/" + id
+		specimens, err := findMirrored(ctx, db, specimenResource.Collection, bson.M{"request.reference": ref})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch specimens"})
+		}
+		reports, err := findMirrored(ctx, db, diagnosticReportResource.Collection, bson.M{"basedOn.reference": ref})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch diagnostic reports"})
+		}
+
+		assembled := make([]map[string]interface{}, 0, len(reports))
+		for _, report := range reports {
+			resultIDs, _ := referenceIDs(report, "result", "Observation")
+			observations := []map[string]interface{}{}
+			if len(resultIDs) > 0 {
+				observations, err = findMirrored(ctx, db, observationResource.Collection, bson.M{"id": bson.M{"$in": resultIDs}})
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch observations"})
+				}
+			}
+			assembled = append(assembled, map[string]interface{}{
+				"report":       report,
+				"observations": observations,
+			})
+		}
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "get",
+			Resource:   serviceRequestResource.Audit,
+			ResourceID: id,
+			StatusCode: http.StatusOK,
+			Details:    map[string]interface{}{"report": true},
+		})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"serviceRequest":    order,
+			"specimens":         specimens,
+			"diagnosticReports": assembled,
+		})
+	}
+}
@@ -1,275 +1,202 @@
 package handlers
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"satusehat-golang/models"
-	"satusehat-golang/utils"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
 )
 
-func CreateLocation(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		body, err := io.ReadAll(c.Request().Body)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read body"})
-		}
-		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+func CreateLocation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, locationResource)
+}
 
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to get token"})
-		}
+func UpdateLocation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, locationResource)
+}
 
-		req, _ := http.NewRequest("POST", "https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Location", bytes.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+token)
+func PatchLocation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, locationResource)
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to post to satusehat"})
-		}
-		defer resp.Body.Close()
+func GetLocation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, locationResource)
+}
 
-		respBody, _ := io.ReadAll(resp.Body)
+// mirrorOnlyLocationParams are search parameters the mirror understands but
+// FHIR R4 Location does not define, so SatuSehat would reject or ignore them.
+var mirrorOnlyLocationParams = []string{"physicalType"}
 
-		var resourceID string
-		if resp.StatusCode == 200 || resp.StatusCode == 201 {
-			// Unmarshal response to extract ID
-			var encResp models.LocationResponse
-			if err := json.Unmarshal(respBody, &encResp); err == nil {
-				resourceID = encResp.ID
-				// Insert into MongoDB
-				var doc interface{}
-				if err := json.Unmarshal(respBody, &doc); err == nil {
-					_, _ = db.Collection("locations").InsertOne(context.Background(), doc)
-				}
+// locationMirrorFilter translates the Location search parameters into a
+// filter on the locations mirror.
+func locationMirrorFilter(query url.Values) bson.M {
+	filter := bson.M{}
+	if name := query.Get("name"); name != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(name), "$options": "i"}
+	}
+	if identifier := query.Get("identifier"); identifier != "" {
+		if system, value, ok := strings.Cut(identifier, "|"); ok {
+			match := bson.M{"value": value}
+			if system != "" {
+				match["system"] = system
 			}
-
-			// Save to audit log with ResourceID
-			_ = utils.LogAudit(db, models.AuditLog{
-				User:       "Admin", // Replace with JWT data if available
-				Action:     "create",
-				Resource:   "location",
-				ResourceID: resourceID, // <-- use extracted ID here
-				StatusCode: resp.StatusCode,
-				Details: map[string]interface{}{
-					"requestBody":  json.RawMessage(body),
-					"responseBody": json.RawMessage(respBody),
-				},
-			})
+			filter["identifier"] = bson.M{"$elemMatch": match}
+		} else {
+			filter["identifier.value"] = identifier
 		}
-
-		return c.JSONBlob(resp.StatusCode, respBody)
 	}
+	if org := query.Get("organization"); org != "" {
+		filter["managingOrganization.reference"] = "Organization/" + strings.TrimPrefix(org, "Organization/")
+	}
+	if partOf := query.Get("partof"); partOf != "" {
+		filter["partOf.reference"] = "Location/" + strings.TrimPrefix(partOf, "Location/")
+	}
+	if physicalType := query.Get("physicalType"); physicalType != "" {
+		filter["physicalType.coding.code"] = physicalType
+	}
+	if status := query.Get("status"); status != "" {
+		filter["status"] = status
+	}
+	return filter
 }
 
-func UpdateLocation(db *mongo.Database) echo.HandlerFunc {
+// SearchLocation searches Locations by name, identifier, organization,
+// partof and status. With ?source=mirror it answers from the locations
+// collection, where physicalType can be searched too; otherwise the query
+// goes to SatuSehat, and physicalType is refused rather than forwarded.
+func SearchLocation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	upstream := searchResource(db, ss, locationResource)
 	return func(c echo.Context) error {
-		locationID := c.Param("id")
-		if locationID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing location ID"})
-		}
-
-		var location map[string]interface{}
-		if err := c.Bind(&location); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
-		}
-
-		reqBody, err := json.Marshal(location)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON"})
-		}
-
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
-		}
-
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Location/%s", locationID)
-
-		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(reqBody))
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
+		query := c.QueryParams()
+		source := query.Get("source")
+		query.Del("source")
+
+		switch source {
+		case "", "satusehat":
+			for _, param := range mirrorOnlyLocationParams {
+				if query.Has(param) {
+					return c.JSON(http.StatusBadRequest, map[string]string{"error": param + " is not a FHIR Location search parameter; add source=mirror to search the mirror by it"})
+				}
+			}
+			c.Request().URL.RawQuery = query.Encode()
+			return upstream(c)
+		case "mirror":
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "source must be satusehat or mirror"})
 		}
 
-		req.Header.Set("Content-Type", "application/fhir+json")
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
+		ctx := c.Request().Context()
+		locations, err := findMirrored(ctx, db, locationResource.Collection, locationMirrorFilter(query))
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch locations"})
 		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-
-		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
-			// Save to audit log
-			_ = utils.LogAudit(db, models.AuditLog{
-				User:       "Admin",
-				Action:     "put",
-				Resource:   "location",
-				ResourceID: locationID,
-				StatusCode: resp.StatusCode,
-				Details: map[string]interface{}{
-					"requestBody":  json.RawMessage(reqBody),
-					"responseBody": json.RawMessage(body),
-				},
-			})
 
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			filter := bson.M{"id": locationID}
-			update := bson.M{"$set": location}
-			_, err := db.Collection("locations").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
-			if err != nil {
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to mirror to MongoDB"})
-			}
-		}
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "search",
+			Resource:   locationResource.Audit,
+			StatusCode: http.StatusOK,
+			Details: map[string]interface{}{
+				"queryParams": query,
+				"source":      "mirror",
+			},
+		})
+		return c.JSON(http.StatusOK, searchset(locations))
+	}
+}
 
-		return c.Blob(resp.StatusCode, "application/fhir+json", body)
+// searchset wraps resources in a FHIR searchset Bundle.
+func searchset(resources []map[string]interface{}) map[string]interface{} {
+	entries := make([]interface{}, 0, len(resources))
+	for _, r := range resources {
+		delete(r, "organization_id")
+		entries = append(entries, map[string]interface{}{"resource": r})
+	}
+	return map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         "searchset",
+		"total":        len(entries),
+		"entry":        entries,
 	}
 }
 
-func PatchLocation(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		locationID := c.Param("id")
-		if locationID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing location ID"})
-		}
+// locationNode is one Location of the tree below a location.
+type locationNode struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name,omitempty"`
+	Location map[string]interface{} `json:"location"`
+	Children []*locationNode        `json:"children"`
+}
 
-		// Read JSON Patch operations
-		var patchOps []map[string]interface{}
-		if err := c.Bind(&patchOps); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+// LocationChildren returns the Locations below a location (partOf), as a
+// tree from the mirror. ?depth=1 returns the direct children only.
+func LocationChildren(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		rootID := c.Param("id")
+		depth := 0
+		if d := c.QueryParam("depth"); d != "" {
+			n, err := strconv.Atoi(d)
+			if err != nil || n < 1 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "depth must be a positive number"})
+			}
+			depth = n
 		}
 
-		reqBody, err := json.Marshal(patchOps)
+		root, err := findOneMirrored(ctx, db, locationResource.Collection, rootID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON patch"})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch location"})
 		}
-
-		// Get token
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
+		if root == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Location not found in the mirror"})
 		}
-
-		// Build PATCH request
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Location/%s", locationID)
-		req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(reqBody))
+		all, err := findMirrored(ctx, db, locationResource.Collection, bson.M{"partOf.reference": bson.M{"$exists": true}})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch locations"})
 		}
 
-		req.Header.Set("Content-Type", "application/json-patch+json")
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
+		children := map[string][]map[string]interface{}{}
+		for _, loc := range all {
+			if parentID, problem := referenceID(loc, "partOf", "Location"); problem == "" {
+				children[parentID] = append(children[parentID], loc)
+			}
 		}
-		defer resp.Body.Close()
-
-		respBody, _ := io.ReadAll(resp.Body)
-
-		// Audit log (record both request and response bodies)
-		_ = utils.LogAudit(db, models.AuditLog{
-			User:       "Admin", // Extract from auth context if available
-			Action:     "patch",
-			Resource:   "location",
-			ResourceID: locationID,
-			StatusCode: resp.StatusCode,
-			Details: map[string]interface{}{
-				"requestBody":  json.RawMessage(reqBody),
-				"responseBody": json.RawMessage(respBody),
-			},
-		})
 
-		// If successful, mirror updated fields to MongoDB
-		if resp.StatusCode == http.StatusOK {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			update := bson.M{}
-			for _, op := range patchOps {
-				if op["op"] == "replace" && op["path"] != nil && op["value"] != nil {
-					path := strings.TrimPrefix(op["path"].(string), "/")
-					update[path] = op["value"]
-				}
+		visited := map[string]bool{}
+		var build func(loc map[string]interface{}, level int) *locationNode
+		build = func(loc map[string]interface{}, level int) *locationNode {
+			id, _ := loc["id"].(string)
+			visited[id] = true
+			delete(loc, "organization_id")
+			node := &locationNode{ID: id, Location: loc, Children: []*locationNode{}}
+			node.Name, _ = loc["name"].(string)
+			if depth > 0 && level >= depth {
+				return node
 			}
-			if len(update) > 0 {
-				filter := bson.M{"id": locationID}
-				_, err := db.Collection("locations").UpdateOne(ctx, filter, bson.M{"$set": update})
-				if err != nil {
-					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to mirror to MongoDB"})
+			for _, child := range children[id] {
+				if childID, _ := child["id"].(string); !visited[childID] { // guard against partOf cycles
+					node.Children = append(node.Children, build(child, level+1))
 				}
 			}
+			return node
 		}
+		tree := build(root, 0)
 
-		// Return SatuSehat response
-		return c.JSONBlob(resp.StatusCode, respBody)
-	}
-}
-
-func GetLocation(db *mongo.Database) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		locationID := c.Param("id")
-		if locationID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing location ID"})
-		}
-
-		// Example: get token for SatuSehat API
-		token, err := utils.GetValidToken(db)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
-		}
-
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Location/%s", locationID)
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
-		}
-
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
-		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-
-		// Log audit for the GET request
-		_ = utils.LogAudit(db, models.AuditLog{
-			User:       "Admin", // Extract from JWT/auth context in real app
-			Action:     "get",
-			Resource:   "location",
-			ResourceID: locationID,
-			StatusCode: resp.StatusCode,
-			Details: map[string]interface{}{
-				"queryParams": c.QueryParams(),
-				"response":    json.RawMessage(body),
-			},
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "list",
+			Resource:   locationResource.Audit,
+			ResourceID: rootID,
+			StatusCode: http.StatusOK,
+			Details:    map[string]interface{}{"children": true},
 		})
-
-		return c.JSONBlob(resp.StatusCode, body)
+		return c.JSON(http.StatusOK, tree)
 	}
 }
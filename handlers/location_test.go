@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLocationMirrorFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bson.M
+	}{
+		{"no parameters", "", bson.M{}},
+		{"name is a case-insensitive literal", "name=R.%2B1", bson.M{"name": bson.M{"$regex": `R\.\+1`, "$options": "i"}}},
+		{"identifier value", "identifier=L-01", bson.M{"identifier.value": "L-01"}},
+		{"identifier system and value", "identifier=" + url.QueryEscape("http://sys-ids.kemkes.go.id/location/1000|L-01"),
+			bson.M{"identifier": bson.M{"$elemMatch": bson.M{"system": "http://sys-ids.kemkes.go.id/location/1000", "value": "L-01"}}}},
+		{"identifier without system", "identifier=" + url.QueryEscape("|L-01"),
+			bson.M{"identifier": bson.M{"$elemMatch": bson.M{"value": "L-01"}}}},
+		{"organization id", "organization=10000004", bson.M{"managingOrganization.reference": "Organization/10000004"}},
+		{"organization reference", "organization=Organization/10000004", bson.M{"managingOrganization.reference": "Organization/10000004"}},
+		{"partof", "partof=Location/abc", bson.M{"partOf.reference": "Location/abc"}},
+		{"physicalType and status", "physicalType=bd&status=active", bson.M{"physicalType.coding.code": "bd", "status": "active"}},
+		{"unknown parameters are ignored", "address=Jakarta", bson.M{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := locationMirrorFilter(query); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("filter = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
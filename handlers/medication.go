@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// kfaSystem is the Kamus Farmasi dan Alat Kesehatan (KFA) code system.
+const kfaSystem = "http://sys-ids.kemkes.go.id/kfa"
+
+var (
+	medicationRequestStatuses = map[string]bool{
+		"active": true, "on-hold": true, "cancelled": true, "completed": true,
+		"entered-in-error": true, "stopped": true, "draft": true, "unknown": true,
+	}
+	medicationRequestIntents = map[string]bool{
+		"proposal": true, "plan": true, "order": true, "original-order": true,
+		"reflex-order": true, "filler-order": true, "instance-order": true, "option": true,
+	}
+	medicationDispenseStatuses = map[string]bool{
+		"preparation": true, "in-progress": true, "cancelled": true, "on-hold": true,
+		"completed": true, "entered-in-error": true, "stopped": true, "declined": true, "unknown": true,
+	}
+	// dispenses with these statuses did not hand anything out
+	voidDispenseStatuses = map[string]bool{"cancelled": true, "entered-in-error": true, "declined": true}
+)
+
+// medicationProblems checks a Medication, stand-alone or contained: its code
+// and the code of every ingredient must be KFA coded. path prefixes the
+// problems, e.g. "contained[0]".
+func medicationProblems(medication map[string]interface{}, path string) []string {
+	var problems []string
+	if rt, _ := medication["resourceType"].(string); rt != "Medication" {
+		problems = append(problems, fmt.Sprintf("%sresourceType %q must be Medication", path, rt))
+	}
+	if !hasSystemCoding(medication, "code", kfaSystem) {
+		problems = append(problems, path+"code.coding must hold a KFA code ("+kfaSystem+")")
+	}
+	ingredients, _ := medication["ingredient"].([]interface{})
+	for i, item := range ingredients {
+		ingredient, _ := item.(map[string]interface{})
+		if _, ok := ingredient["itemCodeableConcept"]; ok && !hasSystemCoding(ingredient, "itemCodeableConcept", kfaSystem) {
+			problems = append(problems, fmt.Sprintf("%singredient[%d].itemCodeableConcept.coding must hold a KFA code", path, i))
+		}
+	}
+	return problems
+}
+
+func validateMedication(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	return medicationProblems(resource, "")
+}
+
+// checkMedicationReference checks medicationReference, which names either a
+// Medication resource ("Medication/<id>") or one in contained ("#<id>").
+func checkMedicationReference(resource map[string]interface{}) []string {
+	ref, _ := resource["medicationReference"].(map[string]interface{})
+	value, _ := ref["reference"].(string)
+	if value == "" {
+		return []string{"medicationReference.reference is required"}
+	}
+
+	localID, ok := strings.CutPrefix(value, "#")
+	if !ok {
+		_, problem := referenceID(resource, "medicationReference", "Medication")
+		if problem != "" {
+			return []string{problem}
+		}
+		return nil
+	}
+
+	contained, _ := resource["contained"].([]interface{})
+	for i, item := range contained {
+		medication, _ := item.(map[string]interface{})
+		if id, _ := medication["id"].(string); id == localID {
+			return medicationProblems(medication, fmt.Sprintf("contained[%d].", i))
+		}
+	}
+	return []string{fmt.Sprintf("medicationReference %s does not match any contained resource", value)}
+}
+
+// validateMedicationRequest checks a prescription before it is sent.
+func validateMedicationRequest(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "MedicationRequest" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be MedicationRequest", rt))
+	}
+	if status, _ := resource["status"].(string); !medicationRequestStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not a medication request status", status))
+	}
+	if intent, _ := resource["intent"].(string); !medicationRequestIntents[intent] {
+		problems = append(problems, fmt.Sprintf("intent %q is not a medication request intent", intent))
+	}
+	problems = append(problems, checkMedicationReference(resource)...)
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	return append(problems, checkEncounterReference(ctx, db, resource)...)
+}
+
+// validateMedicationDispense checks a dispense before it is sent. Its
+// encounter is in context, and every authorizingPrescription must be a
+// MedicationRequest.
+func validateMedicationDispense(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "MedicationDispense" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be MedicationDispense", rt))
+	}
+	if status, _ := resource["status"].(string); !medicationDispenseStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not a medication dispense status", status))
+	}
+	problems = append(problems, checkMedicationReference(resource)...)
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	if encounterID, problem := referenceID(resource, "context", "Encounter"); problem != "" {
+		problems = append(problems, problem)
+	} else if ok, err := mirrored(ctx, db, "encounters", encounterID); err != nil {
+		problems = append(problems, "could not check context.reference: "+err.Error())
+	} else if !ok {
+		problems = append(problems, fmt.Sprintf("context.reference Encounter/%s is not a known encounter of this tenant", encounterID))
+	}
+	for i, item := range prescriptionRefs(resource) {
+		if item == "" {
+			problems = append(problems, fmt.Sprintf("authorizingPrescription[%d].reference must be MedicationRequest/<id>", i))
+		}
+	}
+	return problems
+}
+
+// prescriptionRefs returns the MedicationRequest IDs of a dispense's
+// authorizingPrescription, with "" for entries of another form.
+func prescriptionRefs(dispense map[string]interface{}) []string {
+	list, _ := dispense["authorizingPrescription"].([]interface{})
+	ids := make([]string, 0, len(list))
+	for _, item := range list {
+		ref, _ := item.(map[string]interface{})
+		id, problem := referenceID(map[string]interface{}{"ref": ref}, "ref", "MedicationRequest")
+		if problem != "" {
+			id = ""
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// linkDispense records a mirrored dispense on the prescriptions it fills.
+// It runs after every create, update and patch, so the links follow the
+// current authorizingPrescription: prescriptions the dispense no longer
+// names are unlinked, and a dispense entered in error fills none.
+func linkDispense(ctx context.Context, db *mongo.Database, id string, dispense map[string]interface{}) {
+	requestIDs := []string{}
+	if status, _ := dispense["status"].(string); status != "entered-in-error" {
+		for _, ref := range prescriptionRefs(dispense) {
+			if ref != "" {
+				requestIDs = append(requestIDs, ref)
+			}
+		}
+	}
+
+	requests := db.Collection("medication_requests")
+	tenantID := utils.TenantFrom(ctx)
+	_, err := requests.UpdateMany(ctx,
+		bson.M{"dispense_ids": id, "id": bson.M{"$nin": requestIDs}, "organization_id": tenantID},
+		bson.M{"$pull": bson.M{"dispense_ids": id}},
+	)
+	if err != nil {
+		log.Printf("medication dispense %s: unlinking prescriptions: %v", id, err)
+		return
+	}
+	if len(requestIDs) == 0 {
+		return
+	}
+	_, err = requests.UpdateMany(ctx,
+		bson.M{"id": bson.M{"$in": requestIDs}, "organization_id": tenantID},
+		bson.M{"$addToSet": bson.M{"dispense_ids": id}},
+	)
+	if err != nil {
+		log.Printf("medication dispense %s: linking prescriptions: %v", id, err)
+	}
+}
+
+func CreateMedication(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, medicationResource)
+}
+
+func UpdateMedication(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, medicationResource)
+}
+
+func PatchMedication(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, medicationResource)
+}
+
+func GetMedication(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, medicationResource)
+}
+
+func CreateMedicationRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, medicationRequestResource)
+}
+
+func UpdateMedicationRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, medicationRequestResource)
+}
+
+func PatchMedicationRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, medicationRequestResource)
+}
+
+func GetMedicationRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, medicationRequestResource)
+}
+
+func SearchMedicationRequest(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, medicationRequestResource)
+}
+
+func CreateMedicationDispense(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, medicationDispenseResource)
+}
+
+func UpdateMedicationDispense(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, medicationDispenseResource)
+}
+
+func PatchMedicationDispense(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, medicationDispenseResource)
+}
+
+func GetMedicationDispense(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, medicationDispenseResource)
+}
+
+func SearchMedicationDispense(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, medicationDispenseResource)
+}
+
+// quantityValue reads resource.<path>.value as a number.
+func quantityValue(resource map[string]interface{}, path ...string) (float64, bool) {
+	var node interface{} = resource
+	for _, key := range path {
+		m, _ := node.(map[string]interface{})
+		node = m[key]
+	}
+	m, _ := node.(map[string]interface{})
+	v, ok := m["value"].(float64)
+	return v, ok
+}
+
+// EncounterMedications compares what was prescribed in an encounter with
+// what was dispensed against each prescription, from the mirror.
+func EncounterMedications(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		encounterRef := "Encounter/" + c.Param("id")
+
+		requests, err := findMirrored(ctx, db, medicationRequestResource.Collection, bson.M{"encounter.reference": encounterRef})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch medication requests"})
+		}
+		dispenses, err := findMirrored(ctx, db, medicationDispenseResource.Collection, bson.M{"context.reference": encounterRef})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch medication dispenses"})
+		}
+		byID := make(map[string]map[string]interface{}, len(dispenses))
+		for _, d := range dispenses {
+			id, _ := d["id"].(string)
+			byID[id] = d
+		}
+
+		linked := map[string]bool{}
+		items := make([]map[string]interface{}, 0, len(requests))
+		for _, req := range requests {
+			prescribed, hasPrescribed := quantityValue(req, "dispenseRequest", "quantity")
+			var dispensed float64
+			dispenseList := []map[string]interface{}{}
+
+			ids, _ := req["dispense_ids"].([]interface{})
+			for _, item := range ids {
+				id, _ := item.(string)
+				d, ok := byID[id]
+				if !ok {
+					continue
+				}
+				linked[id] = true
+				status, _ := d["status"].(string)
+				qty, _ := quantityValue(d, "quantity")
+				if !voidDispenseStatuses[status] {
+					dispensed += qty
+				}
+				dispenseList = append(dispenseList, map[string]interface{}{"id": id, "status": status, "quantity": qty})
+			}
+
+			state := "not-dispensed"
+			switch {
+			case dispensed > 0 && hasPrescribed && dispensed < prescribed:
+				state = "partial"
+			case dispensed > 0:
+				state = "dispensed"
+			}
+			item := map[string]interface{}{
+				"medication_request_id": req["id"],
+				"status":                req["status"],
+				"medication":            req["medicationReference"],
+				"dispensed":             dispensed,
+				"dispense_status":       state,
+				"dispenses":             dispenseList,
+			}
+			if hasPrescribed {
+				item["prescribed"] = prescribed
+			}
+			items = append(items, item)
+		}
+
+		unlinked := []map[string]interface{}{}
+		for _, d := range dispenses {
+			if d["status"] == "entered-in-error" {
+				continue
+			}
+			if id, _ := d["id"].(string); !linked[id] {
+				qty, _ := quantityValue(d, "quantity")
+				unlinked = append(unlinked, map[string]interface{}{"id": id, "status": d["status"], "quantity": qty})
+			}
+		}
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "list",
+			Resource:   medicationRequestResource.Audit,
+			ResourceID: c.Param("id"),
+			StatusCode: http.StatusOK,
+		})
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"encounter":          encounterRef,
+			"prescriptions":      items,
+			"unlinked_dispenses": unlinked,
+		})
+	}
+}
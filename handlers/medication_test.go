@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// sentUpdates returns the first update statement of each update command
+// sent, in order.
+func sentUpdates(mt *mtest.T) []bson.Raw {
+	mt.Helper()
+	var updates []bson.Raw
+	for _, ev := range mt.GetAllStartedEvents() {
+		if ev.CommandName == "update" {
+			updates = append(updates, ev.Command.Lookup("updates").Array().Index(0).Value().Document())
+		}
+	}
+	return updates
+}
+
+func stringValues(mt *mtest.T, v bson.RawValue) []string {
+	mt.Helper()
+	values, err := v.Array().Values()
+	if err != nil {
+		mt.Fatal(err)
+	}
+	out := make([]string, len(values))
+	for i, value := range values {
+		out[i] = value.StringValue()
+	}
+	return out
+}
+
+func TestLinkDispense(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := utils.WithTenant(context.Background(), "10000004")
+	updated := func() bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	}
+	dispense := func(status string, prescriptions ...string) map[string]interface{} {
+		refs := make([]interface{}, len(prescriptions))
+		for i, id := range prescriptions {
+			refs[i] = map[string]interface{}{"reference": "MedicationRequest/" + id}
+		}
+		return map[string]interface{}{"status": status, "authorizingPrescription": refs}
+	}
+
+	mt.Run("unlinks dropped prescriptions, then links the current ones", func(mt *mtest.T) {
+		mt.AddMockResponses(updated(), updated())
+		linkDispense(ctx, mt.DB, "disp-1", dispense("completed", "req-2", "req-3"))
+
+		updates := sentUpdates(mt)
+		if len(updates) != 2 {
+			mt.Fatalf("%d updates, want $pull then $addToSet", len(updates))
+		}
+		pull, add := updates[0], updates[1]
+		if id, _ := pull.Lookup("u", "$pull", "dispense_ids").StringValueOK(); id != "disp-1" {
+			mt.Fatalf("first update = %s, want a $pull of disp-1", pull)
+		}
+		if id, _ := pull.Lookup("q", "dispense_ids").StringValueOK(); id != "disp-1" {
+			mt.Errorf("$pull filter = %s, want only requests linked to disp-1", pull.Lookup("q"))
+		}
+		if kept := stringValues(mt, pull.Lookup("q", "id", "$nin")); len(kept) != 2 || kept[0] != "req-2" || kept[1] != "req-3" {
+			mt.Errorf("$pull keeps %v, want the current prescriptions", kept)
+		}
+		if tenant, _ := pull.Lookup("q", "organization_id").StringValueOK(); tenant != "10000004" {
+			mt.Errorf("$pull tenant = %q", tenant)
+		}
+		if id, _ := add.Lookup("u", "$addToSet", "dispense_ids").StringValueOK(); id != "disp-1" {
+			mt.Fatalf("second update = %s, want an $addToSet of disp-1", add)
+		}
+		if linked := stringValues(mt, add.Lookup("q", "id", "$in")); len(linked) != 2 {
+			mt.Errorf("$addToSet links %v, want req-2 and req-3", linked)
+		}
+	})
+
+	mt.Run("entered-in-error unlinks everything", func(mt *mtest.T) {
+		mt.AddMockResponses(updated())
+		linkDispense(ctx, mt.DB, "disp-1", dispense("entered-in-error", "req-2"))
+
+		updates := sentUpdates(mt)
+		if len(updates) != 1 {
+			mt.Fatalf("%d updates, want only the $pull", len(updates))
+		}
+		if kept := stringValues(mt, updates[0].Lookup("q", "id", "$nin")); len(kept) != 0 {
+			mt.Fatalf("$pull keeps %v, want no prescription", kept)
+		}
+	})
+
+	mt.Run("no prescriptions unlinks everything", func(mt *mtest.T) {
+		mt.AddMockResponses(updated())
+		linkDispense(ctx, mt.DB, "disp-1", dispense("completed"))
+		if n := len(sentUpdates(mt)); n != 1 {
+			mt.Fatalf("%d updates, want only the $pull", n)
+		}
+	})
+
+	mt.Run("links are left alone when the unlink fails", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Name: "ShutdownInProgress", Message: "shutting down"}))
+		linkDispense(ctx, mt.DB, "disp-1", dispense("completed", "req-2"))
+		if n := len(sentUpdates(mt)); n != 1 {
+			mt.Fatalf("%d updates after a failed $pull, want no $addToSet", n)
+		}
+	})
+}
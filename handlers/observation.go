@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+)
+
+// observationStatuses is the FHIR observation-status value set.
+var observationStatuses = map[string]bool{
+	"registered": true, "preliminary": true, "final": true, "amended": true,
+	"corrected": true, "cancelled": true, "entered-in-error": true, "unknown": true,
+}
+
+// validateObservation checks a vital sign or lab result before it is sent:
+// it must have a valid status and a code, name its patient and reference an
+// Encounter the mirror knows about.
+func validateObservation(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Observation" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Observation", rt))
+	}
+	if status, _ := resource["status"].(string); !observationStatuses[status] {
+		problems = append(problems, fmt.Sprintf("status %q is not an observation status", status))
+	}
+	if !hasCoding(resource, "code") {
+		problems = append(problems, "code.coding must hold at least one code (LOINC)")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	return append(problems, checkEncounterReference(ctx, db, resource)...)
+}
+
+func CreateObservation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, observationResource)
+}
+
+func UpdateObservation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, observationResource)
+}
+
+func PatchObservation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, observationResource)
+}
+
+func GetObservation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, observationResource)
+}
+
+func SearchObservation(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, observationResource)
+}
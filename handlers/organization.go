@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// validateOrganization checks a sub-unit (poli, department) before it is
+// sent. Its partOf must be the tenant's facility or an Organization the
+// mirror knows about.
+func validateOrganization(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Organization" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Organization", rt))
+	}
+	if name, _ := resource["name"].(string); name == "" {
+		problems = append(problems, "name is required")
+	}
+	if _, ok := resource["partOf"]; ok {
+		parentID, problem := referenceID(resource, "partOf", "Organization")
+		if problem != "" {
+			problems = append(problems, problem)
+		} else if parentID != utils.TenantFrom(ctx) {
+			problems = append(problems, checkMirroredReferences(ctx, db, "partOf", "organizations", "Organization", []string{parentID})...)
+		}
+	}
+	return problems
+}
+
+func CreateOrganization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, organizationResource)
+}
+
+func UpdateOrganization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, organizationResource)
+}
+
+func PatchOrganization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, organizationResource)
+}
+
+func GetOrganization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, organizationResource)
+}
+
+func SearchOrganization(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, organizationResource)
+}
+
+// orgNode is one unit of the facility tree.
+type orgNode struct {
+	ID           string                   `json:"id"`
+	Name         string                   `json:"name,omitempty"`
+	Organization map[string]interface{}   `json:"organization,omitempty"`
+	Locations    []map[string]interface{} `json:"locations"`
+	Units        []*orgNode               `json:"units"`
+}
+
+// OrganizationTree returns the partOf hierarchy below an organization, from
+// the mirror, with the Locations each unit manages. Without :id the tree
+// starts at the tenant's facility.
+func OrganizationTree(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		rootID := c.Param("id")
+		if rootID == "" {
+			rootID = utils.TenantFrom(ctx)
+		}
+		if rootID == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+
+		orgs, err := findMirrored(ctx, db, organizationResource.Collection, bson.M{})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch organizations"})
+		}
+		locations, err := findMirrored(ctx, db, locationResource.Collection, bson.M{"managingOrganization.reference": bson.M{"$exists": true}})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch locations"})
+		}
+
+		byID := map[string]map[string]interface{}{}
+		children := map[string][]string{}
+		for _, org := range orgs {
+			delete(org, "organization_id") // the tenant tag is ours, not FHIR
+			id, _ := org["id"].(string)
+			byID[id] = org
+			if parentID, problem := referenceID(org, "partOf", "Organization"); problem == "" {
+				children[parentID] = append(children[parentID], id)
+			}
+		}
+		managed := map[string][]map[string]interface{}{}
+		for _, loc := range locations {
+			delete(loc, "organization_id")
+			if orgID, problem := referenceID(loc, "managingOrganization", "Organization"); problem == "" {
+				managed[orgID] = append(managed[orgID], loc)
+			}
+		}
+		if byID[rootID] == nil && len(children[rootID]) == 0 && len(managed[rootID]) == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Organization not found in the mirror"})
+		}
+
+		visited := map[string]bool{}
+		var build func(id string) *orgNode
+		build = func(id string) *orgNode {
+			visited[id] = true
+			node := &orgNode{ID: id, Organization: byID[id], Locations: managed[id], Units: []*orgNode{}}
+			if node.Locations == nil {
+				node.Locations = []map[string]interface{}{}
+			}
+			if org := byID[id]; org != nil {
+				node.Name, _ = org["name"].(string)
+			}
+			for _, child := range children[id] {
+				if !visited[child] { // guard against partOf cycles
+					node.Units = append(node.Units, build(child))
+				}
+			}
+			return node
+		}
+		tree := build(rootID)
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "list",
+			Resource:   organizationResource.Audit,
+			ResourceID: rootID,
+			StatusCode: http.StatusOK,
+			Details:    map[string]interface{}{"tree": true},
+		})
+		return c.JSON(http.StatusOK, tree)
+	}
+}
@@ -1,62 +1,487 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
-	"satusehat-golang/models"
-	"satusehat-golang/utils"
-
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// SatuSehat identifier systems for patients.
+const (
+	nikSystem    = "https://fhir.kemkes.go.id/id/nik"
+	nikIbuSystem = "https://fhir.kemkes.go.id/id/nik-ibu"
+	pasporSystem = "https://fhir.kemkes.go.id/id/paspor"
+	kitasSystem  = "https://fhir.kemkes.go.id/id/kitas"
 )
 
-func GetPatient(db *mongo.Database) echo.HandlerFunc {
+// SatuSehat Patient extensions.
+const (
+	birthPlaceExtension         = "https://fhir.kemkes.go.id/r4/StructureDefinition/birthPlace"
+	citizenshipExtension        = "https://fhir.kemkes.go.id/r4/StructureDefinition/citizenshipStatus"
+	administrativeCodeExtension = "https://fhir.kemkes.go.id/r4/StructureDefinition/administrativeCode"
+)
+
+// administrativeLevels are the sub-extensions administrativeCode must carry.
+var administrativeLevels = []string{"province", "city", "district", "village"}
+
+var nikPattern = regexp.MustCompile(`^[0-9]{16}$`)
+
+func GetPatient(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, patientResource)
+}
+
+// PatientSummary is the short form of a Patient returned by the search.
+type PatientSummary struct {
+	IHSNumber string `json:"ihs_number"`
+	Name      string `json:"name,omitempty"`
+	BirthDate string `json:"birthdate,omitempty"`
+	Gender    string `json:"gender,omitempty"`
+}
+
+// patientSearchQuery builds the SatuSehat query for one of the search
+// modes: ?nik=, ?name=&birthdate=&gender= or ?mother_nik= (newborns,
+// optionally with birthdate).
+func patientSearchQuery(params url.Values) (url.Values, string, []string) {
+	nik, motherNIK := params.Get("nik"), params.Get("mother_nik")
+	name, birthdate, gender := params.Get("name"), params.Get("birthdate"), params.Get("gender")
+
+	var problems []string
+	if birthdate != "" {
+		if _, err := time.Parse("2006-01-02", birthdate); err != nil {
+			problems = append(problems, "birthdate must be YYYY-MM-DD")
+		}
+	}
+
+	query := url.Values{}
+	var mode string
+	switch {
+	case nik != "":
+		mode = "nik"
+		if !nikPattern.MatchString(nik) {
+			problems = append(problems, "nik must be 16 digits")
+		}
+		query.Set("identifier", nikSystem+"|"+nik)
+	case motherNIK != "":
+		mode = "mother_nik"
+		if !nikPattern.MatchString(motherNIK) {
+			problems = append(problems, "mother_nik must be 16 digits")
+		}
+		query.Set("identifier", nikIbuSystem+"|"+motherNIK)
+		if birthdate != "" {
+			query.Set("birthdate", birthdate)
+		}
+	case name != "":
+		mode = "name"
+		if birthdate == "" {
+			problems = append(problems, "birthdate is required with name")
+		}
+		if gender != "male" && gender != "female" {
+			problems = append(problems, "gender must be male or female with name")
+		}
+		query.Set("name", name)
+		query.Set("birthdate", birthdate)
+		query.Set("gender", gender)
+	default:
+		problems = append(problems, "send nik, mother_nik, or name with birthdate and gender")
+	}
+	return query, mode, problems
+}
+
+// summarizePatients extracts the summaries from a Patient searchset Bundle.
+func summarizePatients(bundle []byte) []PatientSummary {
+	var b struct {
+		Entry []struct {
+			Resource struct {
+				ID   string `json:"id"`
+				Name []struct {
+					Text   string   `json:"text"`
+					Family string   `json:"family"`
+					Given  []string `json:"given"`
+				} `json:"name"`
+				BirthDate string `json:"birthDate"`
+				Gender    string `json:"gender"`
+			} `json:"resource"`
+		} `json:"entry"`
+	}
+	summaries := []PatientSummary{}
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return summaries
+	}
+	for _, e := range b.Entry {
+		s := PatientSummary{IHSNumber: e.Resource.ID, BirthDate: e.Resource.BirthDate, Gender: e.Resource.Gender}
+		if len(e.Resource.Name) > 0 {
+			n := e.Resource.Name[0]
+			s.Name = n.Text
+			if s.Name == "" {
+				s.Name = strings.TrimSpace(strings.Join(append(n.Given, n.Family), " "))
+			}
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries
+}
+
+// SearchPatient finds patients by NIK, by name, birthdate and gender, or by
+// the mother's NIK for newborns. The response carries a summary of each
+// match next to the SatuSehat Bundle.
+func SearchPatient(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		PatientID := c.Param("id")
-		if PatientID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing Patient ID"})
+		query, mode, problems := patientSearchQuery(c.QueryParams())
+		if len(problems) > 0 {
+			return invalidResource(c, problems)
 		}
 
-		// Example: get token for SatuSehat API
-		token, err := utils.GetValidToken(db)
+		ctx := c.Request().Context()
+		resp, err := ss.Search(ctx, patientResource.Type, query)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
+			return upstreamError(c, err)
 		}
 
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Patient/%s", PatientID)
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
+		// The NIK is personal data; the audit entry keeps only the mode
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "search",
+			Resource:   patientResource.Audit,
+			StatusCode: resp.StatusCode,
+			Details:    map[string]interface{}{"mode": mode},
+		})
+
+		if !resp.OK() {
+			return c.JSONBlob(resp.StatusCode, resp.Body)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"patients": summarizePatients(resp.Body),
+			"bundle":   json.RawMessage(resp.Body),
+		})
+	}
+}
+
+// extension returns the extension with the given url from list, or nil.
+func extension(list interface{}, url string) map[string]interface{} {
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		ext, _ := item.(map[string]interface{})
+		if u, _ := ext["url"].(string); u == url {
+			return ext
+		}
+	}
+	return nil
+}
+
+// patientIdentifiers returns the value of each identifier of resource by
+// system.
+func patientIdentifiers(resource map[string]interface{}) map[string]string {
+	ids := map[string]string{}
+	list, _ := resource["identifier"].([]interface{})
+	for _, item := range list {
+		id, _ := item.(map[string]interface{})
+		system, _ := id["system"].(string)
+		value, _ := id["value"].(string)
+		ids[system] = value
+	}
+	return ids
+}
+
+// validatePatient checks a Patient for one of the registration flows
+// SatuSehat accepts and returns the flow ("nik", "newborn" or "foreign") with
+// the problems found:
+//   - nik: identifier with the patient's NIK
+//   - newborn: identifier with the mother's NIK and multipleBirthInteger
+//     giving the birth order
+//   - foreign: identifier with a passport or KITAS number and the
+//     citizenshipStatus extension set to WNA
+func validatePatient(resource map[string]interface{}) (string, []string) {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Patient" {
+		problems = append(problems, `resourceType must be "Patient"`)
+	}
+
+	ids := patientIdentifiers(resource)
+	var flow string
+	switch {
+	case ids[nikIbuSystem] != "":
+		flow = "newborn"
+		if !nikPattern.MatchString(ids[nikIbuSystem]) {
+			problems = append(problems, "identifier "+nikIbuSystem+" (mother's NIK) must be 16 digits")
+		}
+		if ids[nikSystem] != "" {
+			problems = append(problems, "a newborn is registered with the mother's NIK only; drop the "+nikSystem+" identifier")
+		}
+		order, ok := resource["multipleBirthInteger"].(float64)
+		if !ok || order < 1 || order != float64(int(order)) {
+			problems = append(problems, "multipleBirthInteger (birth order, 1 for the first child) is required for a newborn")
+		}
+	case ids[nikSystem] != "":
+		flow = "nik"
+		if !nikPattern.MatchString(ids[nikSystem]) {
+			problems = append(problems, "identifier "+nikSystem+" must be 16 digits")
+		}
+	case ids[pasporSystem] != "" || ids[kitasSystem] != "":
+		flow = "foreign"
+		citizenship := extension(resource["extension"], citizenshipExtension)
+		if code, _ := citizenship["valueCode"].(string); code != "WNA" {
+			problems = append(problems, "extension "+citizenshipExtension+" with valueCode WNA is required with a passport or KITAS")
+		}
+	default:
+		problems = append(problems, "identifier needs a NIK, the mother's NIK (newborn), a passport or a KITAS number")
+	}
+
+	if citizenship := extension(resource["extension"], citizenshipExtension); citizenship != nil {
+		if code, _ := citizenship["valueCode"].(string); code != "WNI" && code != "WNA" {
+			problems = append(problems, "extension "+citizenshipExtension+" valueCode must be WNI or WNA")
+		}
+	}
+	if birthPlace := extension(resource["extension"], birthPlaceExtension); birthPlace != nil {
+		address, _ := birthPlace["valueAddress"].(map[string]interface{})
+		if city, _ := address["city"].(string); city == "" {
+			problems = append(problems, "extension "+birthPlaceExtension+" needs valueAddress.city")
 		}
+	}
 
-		req.Header.Set("Authorization", "Bearer "+token)
+	names, _ := resource["name"].([]interface{})
+	if len(names) == 0 {
+		problems = append(problems, "name is required")
+	} else {
+		name, _ := names[0].(map[string]interface{})
+		text, _ := name["text"].(string)
+		family, _ := name["family"].(string)
+		given, _ := name["given"].([]interface{})
+		if text == "" && family == "" && len(given) == 0 {
+			problems = append(problems, "name[0] needs text, family or given")
+		}
+	}
+	if gender, _ := resource["gender"].(string); gender != "male" && gender != "female" {
+		problems = append(problems, "gender must be male or female")
+	}
+	birthDate, _ := resource["birthDate"].(string)
+	if born, err := time.Parse("2006-01-02", birthDate); err != nil {
+		problems = append(problems, "birthDate (YYYY-MM-DD) is required")
+	} else if born.After(time.Now()) {
+		problems = append(problems, "birthDate is in the future")
+	}
 
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
+	addresses, _ := resource["address"].([]interface{})
+	for i, item := range addresses {
+		address, _ := item.(map[string]interface{})
+		codes := extension(address["extension"], administrativeCodeExtension)
+		if codes == nil {
+			problems = append(problems, fmt.Sprintf("address[%d] needs the %s extension", i, administrativeCodeExtension))
+			continue
+		}
+		for _, level := range administrativeLevels {
+			sub := extension(codes["extension"], level)
+			if code, _ := sub["valueCode"].(string); code == "" {
+				problems = append(problems, fmt.Sprintf("address[%d] administrativeCode needs a %s valueCode", i, level))
+			}
+		}
+	}
+	return flow, problems
+}
+
+// CreatePatient registers a Patient in SatuSehat for the medical record
+// number in ?mrn=. The body is validated for the NIK, newborn or
+// passport/KITAS flow (see validatePatient). The medical record number is
+// reserved in the patients collection before SatuSehat is called, so two
+// requests for one number cannot both register a patient; the reservation
+// is released when SatuSehat refuses the Patient. The IHS number SatuSehat
+// assigns is then mirrored onto the reservation.
+func CreatePatient(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		mrn := strings.TrimSpace(c.QueryParam("mrn"))
+		if mrn == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "mrn (medical record number) is required"})
+		}
+		body, err := io.ReadAll(c.Request().Body)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "failed to read body"})
+		}
+		var resource map[string]interface{}
+		if err := json.Unmarshal(body, &resource); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+		flow, problems := validatePatient(resource)
+		if len(problems) > 0 {
+			return invalidResource(c, problems)
 		}
-		defer resp.Body.Close()
 
-		body, _ := io.ReadAll(resp.Body)
+		ctx := c.Request().Context()
+		if utils.TenantFrom(ctx) == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+		}
+		reservation, err := reserveMedicalRecord(ctx, db, mrn, flow)
+		if mongo.IsDuplicateKeyError(err) {
+			return medicalRecordConflict(c, db, mrn)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reserve medical record number"})
+		}
 
-		// Log audit for the GET request
-		_ = utils.LogAudit(db, models.AuditLog{
-			User:       "Admin", // Extract from JWT/auth context in real app
-			Action:     "get",
-			Resource:   "Patient",
-			ResourceID: PatientID,
+		resp, err := ss.Create(ctx, patientResource.Type, body)
+		if err != nil {
+			releaseMedicalRecord(ctx, db, reservation, mrn)
+			return upstreamError(c, err)
+		}
+		ihs := createdPatientID(resp.Body)
+		// The identifiers (NIK, mother's NIK, passport, KITAS) are personal
+		// data; the audit entry keeps only the flow and the numbers linked
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "create",
+			Resource:   patientResource.Audit,
+			ResourceID: ihs,
 			StatusCode: resp.StatusCode,
 			Details: map[string]interface{}{
-				"queryParams": c.QueryParams(),
-				"response":    json.RawMessage(body),
+				"registration":          flow,
+				"medical_record_number": mrn,
 			},
 		})
+		if !resp.OK() {
+			releaseMedicalRecord(ctx, db, reservation, mrn)
+			return c.JSONBlob(resp.StatusCode, resp.Body)
+		}
+		if ihs == "" {
+			releaseMedicalRecord(ctx, db, reservation, mrn)
+			return c.JSON(http.StatusBadGateway, map[string]interface{}{
+				"error":   "SatuSehat accepted the Patient but its response has no patient ID; the medical record number was not linked",
+				"patient": json.RawMessage(resp.Body),
+			})
+		}
+
+		if err := linkMedicalRecord(context.WithoutCancel(ctx), db, reservation, ihs, resource); err != nil {
+			log.Printf("patient %s: linking medical record number %s: %v", ihs, mrn, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error":      "Patient was created in SatuSehat but linking the medical record number failed; the number stays reserved",
+				"ihs_number": ihs,
+			})
+		}
+		return c.JSON(resp.StatusCode, map[string]interface{}{
+			"ihs_number":            ihs,
+			"medical_record_number": mrn,
+			"registration":          flow,
+			"patient":               json.RawMessage(resp.Body),
+		})
+	}
+}
 
-		return c.JSONBlob(resp.StatusCode, body)
+// createdPatientID reads the IHS number from a SatuSehat Patient create
+// response, which carries it in data.patient_id rather than in id.
+func createdPatientID(body []byte) string {
+	var res struct {
+		ID   string `json:"id"`
+		Data struct {
+			PatientID string `json:"patient_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return ""
+	}
+	if res.Data.PatientID != "" {
+		return res.Data.PatientID
+	}
+	return res.ID
+}
+
+// reserveMedicalRecord claims mrn for the tenant by inserting a patients
+// document without a FHIR ID. The unique index on organization_id and
+// medical_record_number makes a second claim fail with a duplicate key.
+func reserveMedicalRecord(ctx context.Context, db *mongo.Database, mrn, flow string) (primitive.ObjectID, error) {
+	res, err := db.Collection("patients").InsertOne(ctx, bson.M{
+		"organization_id":       utils.TenantFrom(ctx),
+		"medical_record_number": mrn,
+		"registration":          flow,
+		"reserved_at":           time.Now(),
+		"reserved_by":           utils.UserFrom(ctx),
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+// releaseMedicalRecord drops a reservation that was never linked, so mrn
+// can be registered again.
+func releaseMedicalRecord(ctx context.Context, db *mongo.Database, reservation primitive.ObjectID, mrn string) {
+	_, err := db.Collection("patients").DeleteOne(context.WithoutCancel(ctx),
+		bson.M{"_id": reservation, "id": bson.M{"$exists": false}})
+	if err != nil {
+		log.Printf("releasing medical record number %s: %v", mrn, err)
+	}
+}
+
+// linkMedicalRecord mirrors the registered Patient onto its reservation.
+func linkMedicalRecord(ctx context.Context, db *mongo.Database, reservation primitive.ObjectID, ihs string, resource map[string]interface{}) error {
+	set := bson.M{"id": ihs}
+	for k, v := range resource {
+		if k != "id" && k != "_id" && k != "organization_id" && k != "medical_record_number" && k != "registration" {
+			set[k] = v
+		}
+	}
+	res, err := db.Collection("patients").UpdateOne(ctx, bson.M{"_id": reservation}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("reservation %s is gone", reservation.Hex())
+	}
+	return nil
+}
+
+// medicalRecordConflict answers 409 for a medical record number that is
+// already linked to a patient, or still being registered.
+func medicalRecordConflict(c echo.Context, db *mongo.Database, mrn string) error {
+	linked, err := findMirrored(c.Request().Context(), db, "patients", bson.M{"medical_record_number": mrn})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check medical record number"})
+	}
+	if len(linked) > 0 {
+		if ihs, _ := linked[0]["id"].(string); ihs != "" {
+			return c.JSON(http.StatusConflict, map[string]string{
+				"error":      "medical record number is already linked to a patient",
+				"ihs_number": ihs,
+			})
+		}
+	}
+	return c.JSON(http.StatusConflict, map[string]string{"error": "medical record number is being registered by another request"})
+}
+
+// PatientByMedicalRecord returns the mirrored Patient linked to :mrn.
+func PatientByMedicalRecord(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		docs, err := findMirrored(ctx, db, "patients", bson.M{"medical_record_number": c.Param("mrn"), "id": bson.M{"$exists": true}})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch patient"})
+		}
+		status, ihs := http.StatusNotFound, ""
+		if len(docs) > 0 {
+			status = http.StatusOK
+			ihs, _ = docs[0]["id"].(string)
+		}
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "get",
+			Resource:   patientResource.Audit,
+			ResourceID: ihs,
+			StatusCode: status,
+			Details:    map[string]interface{}{"medical_record_number": c.Param("mrn"), "source": "mirror"},
+		})
+		if status == http.StatusNotFound {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "no patient is linked to this medical record number"})
+		}
+		return c.JSON(http.StatusOK, docs[0])
 	}
 }
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testNIK = "9271060312000001"
+
+func TestPatientSearchQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    string
+		wantMode  string
+		wantQuery string // encoded, "" when problems are expected
+		problem   string
+	}{
+		{"nik", "nik=" + testNIK, "nik", "identifier=" + url.QueryEscape(nikSystem+"|"+testNIK), ""},
+		{"nik wins over name", "nik=" + testNIK + "&name=Budi", "nik", "identifier=" + url.QueryEscape(nikSystem+"|"+testNIK), ""},
+		{"mother's nik", "mother_nik=" + testNIK, "mother_nik", "identifier=" + url.QueryEscape(nikIbuSystem+"|"+testNIK), ""},
+		{"mother's nik with birthdate", "mother_nik=" + testNIK + "&birthdate=2026-10-17", "mother_nik",
+			"birthdate=2026-10-17&identifier=" + url.QueryEscape(nikIbuSystem+"|"+testNIK), ""},
+		{"name", "name=Budi&birthdate=1990-01-31&gender=male", "name", "birthdate=1990-01-31&gender=male&name=Budi", ""},
+		{"short nik", "nik=12345", "nik", "", "nik must be 16 digits"},
+		{"name without birthdate", "name=Budi&gender=male", "name", "", "birthdate is required"},
+		{"name with bad gender", "name=Budi&birthdate=1990-01-31&gender=x", "name", "", "gender must be male or female"},
+		{"bad birthdate", "mother_nik=" + testNIK + "&birthdate=31-01-1990", "mother_nik", "", "YYYY-MM-DD"},
+		{"nothing", "", "", "", "send nik, mother_nik"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, mode, problems := patientSearchQuery(params)
+			if mode != tt.wantMode {
+				t.Errorf("mode = %q, want %q", mode, tt.wantMode)
+			}
+			if tt.problem != "" {
+				if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+					t.Fatalf("problems = %v, want one containing %q", problems, tt.problem)
+				}
+				return
+			}
+			if len(problems) > 0 {
+				t.Fatalf("problems = %v", problems)
+			}
+			if got := query.Encode(); got != tt.wantQuery {
+				t.Fatalf("query = %s, want %s", got, tt.wantQuery)
+			}
+		})
+	}
+}
+
+// testPatient returns a valid NIK Patient, as decoded from a request body,
+// after edit has changed it.
+func testPatient(t *testing.T, edit func(p map[string]interface{})) map[string]interface{} {
+	t.Helper()
+	var p map[string]interface{}
+	err := json.Unmarshal([]byte(`{"resourceType":"Patient",
+		"identifier":[{"use":"official","system":"`+nikSystem+`","value":"`+testNIK+`"}],
+		"name":[{"use":"official","text":"Budi Santoso"}],"gender":"male","birthDate":"1990-01-31",
+		"address":[{"extension":[{"url":"`+administrativeCodeExtension+`","extension":[
+			{"url":"province","valueCode":"31"},{"url":"city","valueCode":"3171"},
+			{"url":"district","valueCode":"317101"},{"url":"village","valueCode":"3171011001"}]}]}]}`), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(p)
+	}
+	return p
+}
+
+func TestValidatePatient(t *testing.T) {
+	identifier := func(system, value string) []interface{} {
+		return []interface{}{map[string]interface{}{"system": system, "value": value}}
+	}
+	citizenship := func(code string) []interface{} {
+		return []interface{}{map[string]interface{}{"url": citizenshipExtension, "valueCode": code}}
+	}
+
+	tests := []struct {
+		name     string
+		edit     func(p map[string]interface{})
+		wantFlow string
+		problem  string // substring of the only problem expected, "" for none
+	}{
+		{"nik", nil, "nik", ""},
+		{"newborn", func(p map[string]interface{}) {
+			p["identifier"] = identifier(nikIbuSystem, testNIK)
+			p["multipleBirthInteger"] = float64(1)
+		}, "newborn", ""},
+		{"newborn without birth order", func(p map[string]interface{}) {
+			p["identifier"] = identifier(nikIbuSystem, testNIK)
+		}, "newborn", "multipleBirthInteger"},
+		{"newborn with fractional birth order", func(p map[string]interface{}) {
+			p["identifier"] = identifier(nikIbuSystem, testNIK)
+			p["multipleBirthInteger"] = 1.5
+		}, "newborn", "multipleBirthInteger"},
+		{"newborn with own nik", func(p map[string]interface{}) {
+			p["identifier"] = append(identifier(nikIbuSystem, testNIK), identifier(nikSystem, testNIK)...)
+			p["multipleBirthInteger"] = float64(2)
+		}, "newborn", "mother's NIK only"},
+		{"passport", func(p map[string]interface{}) {
+			p["identifier"] = identifier(pasporSystem, "A1234567")
+			p["extension"] = citizenship("WNA")
+		}, "foreign", ""},
+		{"kitas without citizenship", func(p map[string]interface{}) {
+			p["identifier"] = identifier(kitasSystem, "2C21AB1234567")
+		}, "foreign", "valueCode WNA is required"},
+		{"unknown citizenship", func(p map[string]interface{}) { p["extension"] = citizenship("XX") }, "nik", "must be WNI or WNA"},
+		{"short nik", func(p map[string]interface{}) { p["identifier"] = identifier(nikSystem, "123") }, "nik", "16 digits"},
+		{"no identifier", func(p map[string]interface{}) { delete(p, "identifier") }, "", "identifier needs"},
+		{"wrong resourceType", func(p map[string]interface{}) { p["resourceType"] = "Person" }, "nik", "resourceType"},
+		{"no name", func(p map[string]interface{}) { delete(p, "name") }, "nik", "name is required"},
+		{"empty name", func(p map[string]interface{}) { p["name"] = []interface{}{map[string]interface{}{"use": "official"}} }, "nik", "name[0]"},
+		{"bad gender", func(p map[string]interface{}) { p["gender"] = "unknown" }, "nik", "gender"},
+		{"future birthDate", func(p map[string]interface{}) { p["birthDate"] = "2999-01-01" }, "nik", "in the future"},
+		{"address without codes", func(p map[string]interface{}) {
+			p["address"] = []interface{}{map[string]interface{}{"city": "Jakarta"}}
+		}, "nik", "address[0] needs"},
+		{"address missing village", func(p map[string]interface{}) {
+			address := p["address"].([]interface{})[0].(map[string]interface{})
+			codes := address["extension"].([]interface{})[0].(map[string]interface{})
+			codes["extension"] = codes["extension"].([]interface{})[:3]
+		}, "nik", "village"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, problems := validatePatient(testPatient(t, tt.edit))
+			if flow != tt.wantFlow {
+				t.Errorf("flow = %q, want %q", flow, tt.wantFlow)
+			}
+			if tt.problem == "" {
+				if len(problems) > 0 {
+					t.Fatalf("problems = %v, want none", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+				t.Fatalf("problems = %v, want one containing %q", problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestCreatedPatientID(t *testing.T) {
+	tests := []struct {
+		body, want string
+	}{
+		{`{"create_patient":{"status":"success"},"data":{"patient_id":"P02478375538"}}`, "P02478375538"},
+		{`{"resourceType":"Patient","id":"P02478375538"}`, "P02478375538"},
+		{`{"data":{"patient_id":"P1"},"id":"P2"}`, "P1"},
+		{`{"data":{}}`, ""},
+		{`not json`, ""},
+	}
+	for _, tt := range tests {
+		if got := createdPatientID([]byte(tt.body)); got != tt.want {
+			t.Errorf("createdPatientID(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
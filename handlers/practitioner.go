@@ -2,61 +2,187 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
 	"net/http"
+	"net/url"
 	"time"
 
-	"satusehat-golang/models"
-	"satusehat-golang/utils"
-
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
 )
 
-func GetPractitioner(db *mongo.Database) echo.HandlerFunc {
+func GetPractitioner(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, practitionerResource)
+}
+
+// practitionerSearchQuery builds the SatuSehat query for ?nik= or
+// ?name=&gender=&birthdate=.
+func practitionerSearchQuery(params url.Values) (url.Values, string, []string) {
+	nik, name := params.Get("nik"), params.Get("name")
+	gender, birthdate := params.Get("gender"), params.Get("birthdate")
+
+	var problems []string
+	query := url.Values{}
+	var mode string
+	switch {
+	case nik != "":
+		mode = "nik"
+		if !nikPattern.MatchString(nik) {
+			problems = append(problems, "nik must be 16 digits")
+		}
+		query.Set("identifier", utils.PractitionerNIKSystem+"|"+nik)
+	case name != "":
+		mode = "name"
+		if gender != "male" && gender != "female" {
+			problems = append(problems, "gender must be male or female with name")
+		}
+		if _, err := time.Parse("2006-01-02", birthdate); err != nil {
+			problems = append(problems, "birthdate (YYYY-MM-DD) is required with name")
+		}
+		query.Set("name", name)
+		query.Set("gender", gender)
+		query.Set("birthdate", birthdate)
+	default:
+		problems = append(problems, "send nik, or name with gender and birthdate")
+	}
+	return query, mode, problems
+}
+
+// SearchPractitioner finds practitioners by NIK, or by name, gender and
+// birthdate. The response carries the IHS ID and name of each match next to
+// the SatuSehat Bundle.
+func SearchPractitioner(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		PractitionerID := c.Param("id")
-		if PractitionerID == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing Practitioner ID"})
+		query, mode, problems := practitionerSearchQuery(c.QueryParams())
+		if len(problems) > 0 {
+			return invalidResource(c, problems)
 		}
 
-		// Example: get token for SatuSehat API
-		token, err := utils.GetValidToken(db)
+		ctx := c.Request().Context()
+		resp, err := ss.Search(ctx, practitionerResource.Type, query)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get token"})
+			return upstreamError(c, err)
 		}
 
-		url := fmt.Sprintf("https://api-satusehat-stg.dto.kemkes.go.id/fhir-r4/v1/Practitioner/%s", PractitionerID)
-		req, err := http.NewRequest(http.MethodGet, url, nil)
+		// The NIK is personal data; the audit entry keeps only the mode
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "search",
+			Resource:   practitionerResource.Audit,
+			StatusCode: resp.StatusCode,
+			Details:    map[string]interface{}{"mode": mode},
+		})
+
+		if !resp.OK() {
+			return c.JSONBlob(resp.StatusCode, resp.Body)
+		}
+		matches, err := utils.ParsePractitioners(resp.Body)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create request"})
+			return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to parse SatuSehat response"})
 		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"practitioners": matches,
+			"bundle":        json.RawMessage(resp.Body),
+		})
+	}
+}
 
-		req.Header.Set("Authorization", "Bearer "+token)
-
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
+// ListRoster lists the tenant's practitioner roster, ordered by staff code.
+func ListRoster(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		cur, err := db.Collection("practitioners").Find(ctx,
+			bson.M{"organization_id": utils.TenantFrom(ctx)},
+			options.Find().SetSort(bson.D{{Key: "staff_id", Value: 1}}),
+		)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to send request"})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch roster"})
+		}
+		defer cur.Close(ctx)
+
+		entries := []models.RosterEntry{}
+		if err := cur.All(ctx, &entries); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode roster"})
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
+
+// GetRosterEntry returns the roster entry of :staff_id, looking up its IHS
+// ID first if it has none yet.
+func GetRosterEntry(roster *utils.Roster) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		entry, err := roster.Resolve(c.Request().Context(), c.Param("staff_id"))
+		if errors.Is(err, utils.ErrStaffNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if entry == nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch roster entry"})
 		}
-		defer resp.Body.Close()
+		// A failed lookup is recorded in the entry itself
+		return c.JSON(http.StatusOK, entry)
+	}
+}
 
-		body, _ := io.ReadAll(resp.Body)
+// PutRosterEntry maps :staff_id to the practitioner with the NIK in the
+// body and looks the practitioner up right away. The entry is kept even if
+// SatuSehat has no match yet; last_error then says why.
+func PutRosterEntry(db *mongo.Database, roster *utils.Roster) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req struct {
+			NIK string `json:"nik"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+		if !nikPattern.MatchString(req.NIK) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "nik must be 16 digits"})
+		}
 
-		// Log audit for the GET request
-		_ = utils.LogAudit(db, models.AuditLog{
-			User:       "Admin", // Extract from JWT/auth context in real app
-			Action:     "get",
-			Resource:   "Practitioner",
-			ResourceID: PractitionerID,
-			StatusCode: resp.StatusCode,
-			Details: map[string]interface{}{
-				"queryParams": c.QueryParams(),
-				"response":    json.RawMessage(body),
-			},
+		ctx := c.Request().Context()
+		staffID := c.Param("staff_id")
+		entry, err := roster.Put(ctx, staffID, req.NIK, utils.UserFrom(ctx))
+		if errors.Is(err, utils.ErrNoTenant) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if entry == nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store roster entry"})
+		}
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "put",
+			Resource:   "practitioner_roster",
+			ResourceID: staffID,
+			StatusCode: http.StatusOK,
+			Details:    map[string]interface{}{"ihs_id": entry.IHSID, "last_error": entry.LastError},
 		})
+		return c.JSON(http.StatusOK, entry)
+	}
+}
 
-		return c.JSONBlob(resp.StatusCode, body)
+func DeleteRosterEntry(db *mongo.Database, roster *utils.Roster) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		staffID := c.Param("staff_id")
+		err := roster.Delete(ctx, staffID)
+		if errors.Is(err, utils.ErrStaffNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete roster entry"})
+		}
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "delete",
+			Resource:   "practitioner_roster",
+			ResourceID: staffID,
+			StatusCode: http.StatusNoContent,
+		})
+		return c.NoContent(http.StatusNoContent)
 	}
 }
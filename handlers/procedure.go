@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+)
+
+const icd9cmSystem = "http://hl7.org/fhir/sid/icd-9-cm"
+
+// validateProcedure checks a Procedure before it is sent: its code must
+// carry an ICD-9-CM coding, a category (e.g. counseling) must be coded, and
+// the subject and encounter references must be present and well-formed.
+// The encounter is not looked up in the mirror.
+func validateProcedure(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	var problems []string
+	if rt, _ := resource["resourceType"].(string); rt != "Procedure" {
+		problems = append(problems, fmt.Sprintf("resourceType %q must be Procedure", rt))
+	}
+	if !hasSystemCoding(resource, "code", icd9cmSystem) {
+		problems = append(problems, "code.coding must hold an ICD-9-CM code ("+icd9cmSystem+")")
+	}
+	if _, ok := resource["category"]; ok && !hasCoding(resource, "category") {
+		problems = append(problems, "category.coding must hold at least one code")
+	}
+	if _, problem := referenceID(resource, "subject", "Patient"); problem != "" {
+		problems = append(problems, problem)
+	}
+	if _, problem := referenceID(resource, "encounter", "Encounter"); problem != "" {
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+func CreateProcedure(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return createResource(db, ss, procedureResource)
+}
+
+func UpdateProcedure(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return updateResource(db, ss, procedureResource)
+}
+
+func PatchProcedure(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return patchResource(db, ss, procedureResource)
+}
+
+func GetProcedure(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return getResource(db, ss, procedureResource)
+}
+
+func SearchProcedure(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return searchResource(db, ss, procedureResource)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// referenceID returns the ID from resource[field].reference, which must
+// have the form "<resourceType>/<id>". It returns a problem description
+// when the reference is missing or of another type.
+func referenceID(resource map[string]interface{}, field, resourceType string) (string, string) {
+	ref, _ := resource[field].(map[string]interface{})
+	value, _ := ref["reference"].(string)
+	if value == "" {
+		return "", fmt.Sprintf("%s.reference is required", field)
+	}
+	id, ok := strings.CutPrefix(value, resourceType+"/")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		return "", fmt.Sprintf("%s.reference %q must be %s/<id>", field, value, resourceType)
+	}
+	return id, ""
+}
+
+// referenceIDs returns the IDs from the list resource[field][].reference,
+// each of which must be "<resourceType>/<id>", and the problems found.
+func referenceIDs(resource map[string]interface{}, field, resourceType string) ([]string, []string) {
+	list, _ := resource[field].([]interface{})
+	var ids, problems []string
+	for i, item := range list {
+		ref, _ := item.(map[string]interface{})
+		id, problem := referenceID(map[string]interface{}{field: ref}, field, resourceType)
+		if problem != "" {
+			problems = append(problems, fmt.Sprintf("%s[%d]%s", field, i, strings.TrimPrefix(problem, field)))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, problems
+}
+
+// mirrored reports whether the tenant's mirror collection holds a resource
+// with the given ID.
+func mirrored(ctx context.Context, db *mongo.Database, collection, id string) (bool, error) {
+	n, err := db.Collection(collection).CountDocuments(ctx, bson.M{"id": id, "organization_id": utils.TenantFrom(ctx)})
+	return n > 0, err
+}
+
+// findMirrored returns the tenant's documents in a mirror collection that
+// match filter, as plain JSON values (maps, []interface{}, float64), so they
+// can be read the same way as request bodies.
+func findMirrored(ctx context.Context, db *mongo.Database, collection string, filter bson.M) ([]map[string]interface{}, error) {
+	filter["organization_id"] = utils.TenantFrom(ctx)
+	cur, err := db.Collection(collection).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	docs := []map[string]interface{}{}
+	for cur.Next(ctx) {
+		ext, err := bson.MarshalExtJSON(cur.Current, false, false)
+		if err != nil {
+			return nil, err
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(ext, &doc); err != nil {
+			return nil, err
+		}
+		delete(doc, "_id")
+		docs = append(docs, doc)
+	}
+	return docs, cur.Err()
+}
+
+// findOneMirrored returns the tenant's mirrored resource with the given ID,
+// or nil if there is none.
+func findOneMirrored(ctx context.Context, db *mongo.Database, collection, id string) (map[string]interface{}, error) {
+	docs, err := findMirrored(ctx, db, collection, bson.M{"id": id})
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	return docs[0], nil
+}
+
+// checkEncounterReference checks resource.encounter against the encounters
+// mirror and returns the problems found.
+func checkEncounterReference(ctx context.Context, db *mongo.Database, resource map[string]interface{}) []string {
+	id, problem := referenceID(resource, "encounter", "Encounter")
+	if problem != "" {
+		return []string{problem}
+	}
+	ok, err := mirrored(ctx, db, "encounters", id)
+	if err != nil {
+		return []string{"could not check encounter.reference: " + err.Error()}
+	}
+	if !ok {
+		return []string{fmt.Sprintf("encounter.reference Encounter/%s is not a known encounter of this tenant", id)}
+	}
+	return nil
+}
+
+// hasCoding reports whether resource[field].coding holds at least one code.
+func hasCoding(resource map[string]interface{}, field string) bool {
+	return hasSystemCoding(resource, field, "")
+}
+
+// hasSystemCoding reports whether resource[field].coding holds a code from
+// system, or from any system if system is "". A field holding a list of
+// concepts (like category) matches if any of them does.
+func hasSystemCoding(resource map[string]interface{}, field, system string) bool {
+	var concepts []interface{}
+	switch v := resource[field].(type) {
+	case map[string]interface{}:
+		concepts = []interface{}{v}
+	case []interface{}:
+		concepts = v
+	}
+	for _, c := range concepts {
+		concept, _ := c.(map[string]interface{})
+		codings, _ := concept["coding"].([]interface{})
+		for _, c := range codings {
+			coding, _ := c.(map[string]interface{})
+			code, _ := coding["code"].(string)
+			codeSystem, _ := coding["system"].(string)
+			if code != "" && (system == "" || codeSystem == system) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/middleware"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// ListRoles lists the roles and their permissions.
+func ListRoles(db *mongo.Database) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		cur, err := db.Collection("roles").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch roles"})
+		}
+		defer cur.Close(ctx)
+
+		roles := []models.Role{}
+		if err := cur.All(ctx, &roles); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decode roles"})
+		}
+		return c.JSON(http.StatusOK, roles)
+	}
+}
+
+// requireGlobalOperator reports whether the caller may edit roles, and
+// answers the request when it may not. Roles are shared by every tenant, so
+// a tenant-bound caller must not change them.
+func requireGlobalOperator(c echo.Context, db *mongo.Database, policies *utils.PolicyStore, action string) (bool, error) {
+	ctx := c.Request().Context()
+	ok, err := policies.GlobalOperator(ctx, utils.PrincipalFrom(ctx))
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load roles"})
+	}
+	if !ok {
+		return false, middleware.Deny(c, db, "role", action,
+			"Roles apply to every tenant; only a caller not bound to a tenant with \"*\" on \"*\" may change them",
+			map[string]interface{}{"role": c.Param("name")})
+	}
+	return true, nil
+}
+
+// PutRole creates or replaces the role named by :name. Only a global
+// operator may call it.
+func PutRole(db *mongo.Database, policies *utils.PolicyStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := requireGlobalOperator(c, db, policies, "put"); !ok {
+			return err
+		}
+		var role models.Role
+		if err := c.Bind(&role); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+		role.Name = c.Param("name")
+		for _, p := range role.Permissions {
+			if p.Resource == "" || p.Action == "" {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "every permission needs a resource and an action (\"*\" for any)"})
+			}
+		}
+
+		ctx := c.Request().Context()
+		role.UpdatedAt = time.Now()
+		role.UpdatedBy = utils.UserFrom(ctx)
+		_, err := db.Collection("roles").UpdateOne(ctx,
+			bson.M{"name": role.Name},
+			bson.M{"$set": bson.M{
+				"name":        role.Name,
+				"description": role.Description,
+				"permissions": role.Permissions,
+				"updated_at":  role.UpdatedAt,
+				"updated_by":  role.UpdatedBy,
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store role"})
+		}
+		policies.Invalidate()
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "put",
+			Resource:   "role",
+			ResourceID: role.Name,
+			StatusCode: http.StatusOK,
+			Details:    map[string]interface{}{"permissions": role.Permissions},
+		})
+		return c.JSON(http.StatusOK, role)
+	}
+}
+
+// DeleteRole deletes the role named by :name. Only a global operator may
+// call it.
+func DeleteRole(db *mongo.Database, policies *utils.PolicyStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := requireGlobalOperator(c, db, policies, "delete"); !ok {
+			return err
+		}
+		ctx := c.Request().Context()
+		name := c.Param("name")
+		res, err := db.Collection("roles").DeleteOne(ctx, bson.M{"name": name})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete role"})
+		}
+		if res.DeletedCount == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+		}
+		policies.Invalidate()
+
+		_ = utils.LogAudit(ctx, db, models.AuditLog{
+			Action:     "delete",
+			Resource:   "role",
+			ResourceID: name,
+			StatusCode: http.StatusOK,
+		})
+		return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// TokenStatus reports the state of the SatuSehat access tokens: when they
+// expire and the last refresh error, if any. Only the selected tenant is
+// shown, or every tenant for an explicit cross-tenant read. The tokens
+// themselves are never returned.
+func TokenStatus(tokens *utils.TokenManager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ctx := c.Request().Context()
+		status := tokens.Status()
+		if tenantID := utils.TenantFrom(ctx); tenantID != "" {
+			return c.JSON(http.StatusOK, status[tenantID])
+		}
+		if utils.AllTenantsFrom(ctx) {
+			return c.JSON(http.StatusOK, status)
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": utils.ErrNoTenant.Error()})
+	}
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+const (
+	loincSystem               = "http://loinc.org"
+	ucumSystem                = "http://unitsofmeasure.org"
+	observationCategorySystem = "http://terminology.hl7.org/CodeSystem/observation-category"
+)
+
+// VitalSigns is the flat payload of the vital-signs endpoint. Every
+// measurement is optional, but blood pressure needs both values.
+type VitalSigns struct {
+	EncounterID string `json:"encounter_id"`
+	// PatientID defaults to the subject of the encounter.
+	PatientID      string `json:"patient_id"`
+	PractitionerID string `json:"practitioner_id"`
+	// Effective is when the measurement was taken (RFC 3339); defaults to now.
+	Effective string `json:"effective"`
+
+	Systolic  *float64 `json:"systolic"`
+	Diastolic *float64 `json:"diastolic"`
+	// Unit is the blood pressure unit: mm[Hg] (default); mmHg is accepted as
+	// an alias.
+	Unit            string   `json:"unit"`
+	HeartRate       *float64 `json:"heart_rate"`
+	RespiratoryRate *float64 `json:"respiratory_rate"`
+	Temperature     *float64 `json:"temperature"`
+	// TemperatureUnit is Cel (default) or [degF].
+	TemperatureUnit string `json:"temperature_unit"`
+}
+
+// vitalSign is one LOINC-coded measurement with its UCUM unit.
+type vitalSign struct {
+	Code, Display string
+	Value         float64
+	Unit, UCUM    string
+}
+
+// measurements returns the vital signs present in v, or the problems found.
+func (v *VitalSigns) measurements() ([]vitalSign, []string) {
+	var signs []vitalSign
+	var problems []string
+
+	if (v.Systolic == nil) != (v.Diastolic == nil) {
+		problems = append(problems, "systolic and diastolic must be sent together")
+	} else if v.Systolic != nil {
+		switch v.Unit {
+		case "", "mm[Hg]", "mmHg":
+		default:
+			problems = append(problems, fmt.Sprintf("unit %q is not supported for blood pressure; use mm[Hg]", v.Unit))
+		}
+		signs = append(signs,
+			vitalSign{"8480-6", "Systolic blood pressure", *v.Systolic, "mm[Hg]", "mm[Hg]"},
+			vitalSign{"8462-4", "Diastolic blood pressure", *v.Diastolic, "mm[Hg]", "mm[Hg]"},
+		)
+	}
+	if v.HeartRate != nil {
+		signs = append(signs, vitalSign{"8867-4", "Heart rate", *v.HeartRate, "beats/minute", "/min"})
+	}
+	if v.RespiratoryRate != nil {
+		signs = append(signs, vitalSign{"9279-1", "Respiratory rate", *v.RespiratoryRate, "breaths/minute", "/min"})
+	}
+	if v.Temperature != nil {
+		switch v.TemperatureUnit {
+		case "", "Cel", "C":
+			signs = append(signs, vitalSign{"8310-5", "Body temperature", *v.Temperature, "C", "Cel"})
+		case "[degF]", "F":
+			signs = append(signs, vitalSign{"8310-5", "Body temperature", *v.Temperature, "F", "[degF]"})
+		default:
+			problems = append(problems, fmt.Sprintf("temperature_unit %q must be Cel or [degF]", v.TemperatureUnit))
+		}
+	}
+
+	for _, s := range signs {
+		if s.Value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive", s.Display))
+		}
+	}
+	if len(signs) == 0 && len(problems) == 0 {
+		problems = append(problems, "at least one of systolic/diastolic, heart_rate, respiratory_rate or temperature is required")
+	}
+	return signs, problems
+}
+
+// observation builds the FHIR Observation for one vital sign.
+func (v *VitalSigns) observation(s vitalSign, effective string) map[string]interface{} {
+	obs := map[string]interface{}{
+		"resourceType": "Observation",
+		"status":       "final",
+		"category": []interface{}{map[string]interface{}{
+			"coding": []interface{}{map[string]interface{}{
+				"system":  observationCategorySystem,
+				"code":    "vital-signs",
+				"display": "Vital Signs",
+			}},
+		}},
+		"code": map[string]interface{}{
+			"coding": []interface{}{map[string]interface{}{
+				"system":  loincSystem,
+				"code":    s.Code,
+				"display": s.Display,
+			}},
+		},
+		"subject":           map[string]interface{}{"reference": "Patient/" + v.PatientID},
+		"encounter":         map[string]interface{}{"reference": "Encounter/" + v.EncounterID},
+		"effectiveDateTime": effective,
+		"issued":            time.Now().Format(time.RFC3339),
+		"valueQuantity": map[string]interface{}{
+			"value":  s.Value,
+			"unit":   s.Unit,
+			"system": ucumSystem,
+			"code":   s.UCUM,
+		},
+	}
+	if v.PractitionerID != "" {
+		obs["performer"] = []interface{}{map[string]interface{}{"reference": "Practitioner/" + v.PractitionerID}}
+	}
+	return obs
+}
+
+// encounterSubject returns the patient ID the mirrored encounter refers to
+// ("" if it names none) and whether the encounter is in the tenant's mirror.
+func encounterSubject(ctx context.Context, db *mongo.Database, encounterID string) (string, bool, error) {
+	enc, err := findOneMirrored(ctx, db, "encounters", encounterID)
+	if err != nil || enc == nil {
+		return "", false, err
+	}
+	patientID, _ := referenceID(enc, "subject", "Patient")
+	return patientID, true, nil
+}
+
+// CreateVitalSigns turns a flat vital-signs payload into LOINC/UCUM coded
+// Observations for an existing encounter and creates them in one
+// transaction Bundle, so SatuSehat either creates all of them or none.
+func CreateVitalSigns(db *mongo.Database, ss *satusehat.Client) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req VitalSigns
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON body"})
+		}
+
+		signs, problems := req.measurements()
+		effective := time.Now().Format(time.RFC3339)
+		if req.Effective != "" {
+			t, err := time.Parse(time.RFC3339, req.Effective)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("effective %q is not an RFC 3339 time", req.Effective))
+			} else {
+				effective = t.Format(time.RFC3339)
+			}
+		}
+
+		ctx := c.Request().Context()
+		if req.EncounterID == "" {
+			problems = append(problems, "encounter_id is required")
+		} else {
+			patientID, ok, err := encounterSubject(ctx, db, req.EncounterID)
+			switch {
+			case err != nil:
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to look up encounter"})
+			case !ok:
+				problems = append(problems, fmt.Sprintf("encounter_id %s is not a known encounter of this tenant", req.EncounterID))
+			case req.PatientID == "":
+				req.PatientID = patientID
+			case patientID != "" && patientID != req.PatientID:
+				problems = append(problems, fmt.Sprintf("patient_id %s is not the subject of encounter %s", req.PatientID, req.EncounterID))
+			}
+		}
+		if req.PatientID == "" && len(problems) == 0 {
+			problems = append(problems, "patient_id is required; the encounter names no patient")
+		}
+		if len(problems) > 0 {
+			return invalidResource(c, problems)
+		}
+
+		observations := make([]map[string]interface{}, len(signs))
+		entries := make([]interface{}, len(signs))
+		for i, s := range signs {
+			observations[i] = req.observation(s, effective)
+			fullURL, err := urnUUID()
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to build bundle"})
+			}
+			entries[i] = map[string]interface{}{
+				"fullUrl":  fullURL,
+				"resource": observations[i],
+				"request":  map[string]interface{}{"method": http.MethodPost, "url": observationResource.Type},
+			}
+		}
+		bundle, err := json.Marshal(map[string]interface{}{
+			"resourceType": "Bundle",
+			"type":         "transaction",
+			"entry":        entries,
+		})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal JSON"})
+		}
+
+		resp, err := ss.Transaction(ctx, bundle)
+		if err != nil {
+			return upstreamError(c, err)
+		}
+		if !resp.OK() {
+			_ = utils.LogAudit(ctx, db, models.AuditLog{
+				Action:     "create",
+				Resource:   observationResource.Audit,
+				StatusCode: resp.StatusCode,
+				Details: map[string]interface{}{
+					"requestBody":  json.RawMessage(bundle),
+					"responseBody": json.RawMessage(resp.Body),
+				},
+			})
+			return c.JSON(resp.StatusCode, map[string]interface{}{
+				"error":    "SatuSehat rejected the vital signs; no observation was created",
+				"response": json.RawMessage(resp.Body),
+			})
+		}
+
+		created := transactionIDs(resp.Body, observationResource.Type)
+		results := make([]map[string]interface{}, len(signs))
+		for i, s := range signs {
+			results[i] = map[string]interface{}{"code": s.Code, "display": s.Display}
+			if i >= len(created) || created[i] == "" {
+				continue
+			}
+			body, _ := json.Marshal(observations[i])
+			observations[i]["id"] = created[i]
+			mirror, err := json.Marshal(observations[i])
+			if err != nil {
+				continue
+			}
+			results[i]["id"] = recordCreated(ctx, db, observationResource, body,
+				&satusehat.Response{StatusCode: http.StatusCreated, Body: mirror})
+		}
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"observations": results,
+			"bundle":       json.RawMessage(resp.Body),
+		})
+	}
+}
+
+// transactionIDs returns the IDs of the resources a transaction-response
+// Bundle reports, in entry order, read from each entry's response.location
+// ("<type>/<id>/_history/<version>") or its resource. An entry without one
+// gives "".
+func transactionIDs(body []byte, resourceType string) []string {
+	var res struct {
+		Entry []struct {
+			Resource struct {
+				ID string `json:"id"`
+			} `json:"resource"`
+			Response struct {
+				Location string `json:"location"`
+			} `json:"response"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil
+	}
+	ids := make([]string, len(res.Entry))
+	for i, e := range res.Entry {
+		ids[i] = e.Resource.ID
+		if location := e.Response.Location; ids[i] == "" && location != "" {
+			if n := strings.Index(location, resourceType+"/"); n >= 0 {
+				location = location[n+len(resourceType)+1:]
+			}
+			ids[i], _, _ = strings.Cut(location, "/")
+		}
+	}
+	return ids
+}
+
+// urnUUID returns a random urn:uuid for a Bundle entry's fullUrl.
+func urnUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
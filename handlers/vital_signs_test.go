@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVitalSignsMeasurements(t *testing.T) {
+	v := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name      string
+		in        VitalSigns
+		wantCodes []string
+		wantUCUM  []string
+		problem   string // substring of the only problem expected, "" for none
+	}{
+		{"blood pressure", VitalSigns{Systolic: v(120), Diastolic: v(80)}, []string{"8480-6", "8462-4"}, []string{"mm[Hg]", "mm[Hg]"}, ""},
+		{"mmHg alias", VitalSigns{Systolic: v(120), Diastolic: v(80), Unit: "mmHg"}, []string{"8480-6", "8462-4"}, []string{"mm[Hg]", "mm[Hg]"}, ""},
+		{"all signs", VitalSigns{Systolic: v(120), Diastolic: v(80), HeartRate: v(80), RespiratoryRate: v(20), Temperature: v(36.6)},
+			[]string{"8480-6", "8462-4", "8867-4", "9279-1", "8310-5"}, []string{"mm[Hg]", "mm[Hg]", "/min", "/min", "Cel"}, ""},
+		{"fahrenheit", VitalSigns{Temperature: v(98.6), TemperatureUnit: "F"}, []string{"8310-5"}, []string{"[degF]"}, ""},
+		{"systolic alone", VitalSigns{Systolic: v(120)}, nil, nil, "sent together"},
+		{"unsupported pressure unit", VitalSigns{Systolic: v(16), Diastolic: v(10), Unit: "kPa"}, []string{"8480-6", "8462-4"}, []string{"mm[Hg]", "mm[Hg]"}, `"kPa"`},
+		{"unsupported temperature unit", VitalSigns{Temperature: v(300), TemperatureUnit: "K"}, nil, nil, "temperature_unit"},
+		{"non-positive value", VitalSigns{HeartRate: v(0)}, []string{"8867-4"}, []string{"/min"}, "Heart rate must be positive"},
+		{"nothing measured", VitalSigns{}, nil, nil, "at least one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signs, problems := tt.in.measurements()
+			var codes, ucum []string
+			for _, s := range signs {
+				codes = append(codes, s.Code)
+				ucum = append(ucum, s.UCUM)
+			}
+			if !reflect.DeepEqual(codes, tt.wantCodes) || !reflect.DeepEqual(ucum, tt.wantUCUM) {
+				t.Errorf("codes = %v %v, want %v %v", codes, ucum, tt.wantCodes, tt.wantUCUM)
+			}
+			if tt.problem == "" {
+				if len(problems) > 0 {
+					t.Fatalf("problems = %v, want none", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.problem) {
+				t.Fatalf("problems = %v, want one containing %q", problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestTransactionIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"locations", `{"resourceType":"Bundle","type":"transaction-response","entry":[
+			{"response":{"status":"201 Created","location":"Observation/obs-1/_history/1"}},
+			{"response":{"status":"201 Created","location":"https://fhir.example/Observation/obs-2/_history/1"}}]}`,
+			[]string{"obs-1", "obs-2"}},
+		{"location without history", `{"entry":[{"response":{"location":"Observation/obs-1"}}]}`, []string{"obs-1"}},
+		{"resource id wins", `{"entry":[{"resource":{"id":"obs-r"},"response":{"location":"Observation/obs-l/_history/1"}}]}`, []string{"obs-r"}},
+		{"entry without id", `{"entry":[{"response":{"status":"201 Created"}},{"response":{"location":"Observation/obs-2"}}]}`, []string{"", "obs-2"}},
+		{"no entries", `{"resourceType":"Bundle"}`, []string{}},
+		{"not json", `<html>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transactionIDs([]byte(tt.body), "Observation"); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("transactionIDs = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
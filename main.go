@@ -2,52 +2,257 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"satusehat-golang/handlers"
+	"github.com/jaisyullah/satusehat-be-golang/auth"
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+	"github.com/jaisyullah/satusehat-be-golang/handlers"
+	"github.com/jaisyullah/satusehat-be-golang/middleware"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to a YAML or TOML config file (default: $CONFIG_FILE)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := encryption.LoadKeyring(cfg.Encryption.KeysFile, cfg.Encryption.Keys, cfg.Encryption.PrimaryKeyID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwts, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	e := echo.New()
 
+	// Every request context derives from baseCtx, which is cancelled once the
+	// shutdown grace period is over.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	e.Server.BaseContext = func(net.Listener) context.Context { return baseCtx }
+
 	// Inisialisasi MongoDB
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI).SetTimeout(cfg.Mongo.Timeout))
 	if err != nil {
 		log.Fatal(err)
 	}
-	db := client.Database("satusehat_mirror")
+	db := client.Database(cfg.Mongo.Database)
+	if cfg.DefaultTenant != "" {
+		if err := utils.AdoptLegacyTenant(ctx, db, keys, cfg.DefaultTenant); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := utils.MigrateCredentialVersions(ctx, db); err != nil {
+		log.Fatal(err)
+	}
+	if err := utils.EnsureIndexes(ctx, db); err != nil {
+		log.Fatal(err)
+	}
+	if err := utils.EnsureDefaultRoles(ctx, db); err != nil {
+		log.Fatal(err)
+	}
+	policies := utils.NewPolicyStore(db)
+	tokens := utils.NewTokenManager(db, cfg, keys)
+	go tokens.Run(baseCtx)
+	ss := satusehat.NewClient(tokens,
+		satusehat.WithTimeout(cfg.SatuSehat.Timeout),
+		satusehat.WithTransactionTimeout(cfg.SatuSehat.TransactionTimeout),
+	)
+	roster := utils.NewRoster(db, ss, cfg.SatuSehat.RosterRefresh)
+	go roster.Run(baseCtx)
+	log.Printf("running in %s mode, default SatuSehat profile %q", cfg.Mode, cfg.SatuSehat.DefaultProfile)
 
 	// Routing
+	api := e.Group("/simrs/v1", middleware.Auth(db, jwts), middleware.Tenant(db, cfg))
+	// allow restricts a route to callers whose roles grant action on resource
+	allow := func(resource, action string) echo.MiddlewareFunc {
+		return middleware.Authorize(db, policies, resource, action)
+	}
+
 	// resource: Encounter
-	e.GET("/simrs/v1/encounter/:id", handlers.GetEncounter(db))
-	e.POST("/simrs/v1/encounter/create", handlers.CreateEncounter(db))
-	e.POST("/simrs/v1/encounter/update/:id", handlers.UpdateEncounter(db))
-	e.PATCH("/simrs/v1/encounter/patch/:id", handlers.PatchEncounter(db))
+	api.GET("/encounter/:id", handlers.GetEncounter(db, ss), allow("encounter", "get"))
+	api.POST("/encounter/create", handlers.CreateEncounter(db, ss, roster), allow("encounter", "create"))
+	api.POST("/encounter/update/:id", handlers.UpdateEncounter(db, ss, roster), allow("encounter", "put"))
+	api.PATCH("/encounter/patch/:id", handlers.PatchEncounter(db, ss), allow("encounter", "patch"))
+
+	// resource: Organization
+	api.GET("/organization", handlers.SearchOrganization(db, ss), allow("organization", "search"))
+	api.GET("/organization/tree", handlers.OrganizationTree(db), allow("organization", "list"))
+	api.GET("/organization/:id", handlers.GetOrganization(db, ss), allow("organization", "get"))
+	api.GET("/organization/:id/tree", handlers.OrganizationTree(db), allow("organization", "list"))
+	api.POST("/organization/create", handlers.CreateOrganization(db, ss), allow("organization", "create"))
+	api.POST("/organization/update/:id", handlers.UpdateOrganization(db, ss), allow("organization", "put"))
+	api.PATCH("/organization/patch/:id", handlers.PatchOrganization(db, ss), allow("organization", "patch"))
 
 	// resource: Location
-	e.GET("/simrs/v1/location/:id", handlers.GetEncounter(db))
-	e.POST("/simrs/v1/location/create", handlers.CreateLocation(db))
-	e.POST("/simrs/v1/location/update/:id", handlers.UpdateLocation(db))
-	e.PATCH("/simrs/v1/location/patch/:id", handlers.PatchLocation(db))
+	api.GET("/location", handlers.SearchLocation(db, ss), allow("location", "search"))
+	api.GET("/location/:id", handlers.GetLocation(db, ss), allow("location", "get"))
+	api.GET("/location/:id/children", handlers.LocationChildren(db), allow("location", "list"))
+	api.POST("/location/create", handlers.CreateLocation(db, ss), allow("location", "create"))
+	api.POST("/location/update/:id", handlers.UpdateLocation(db, ss), allow("location", "put"))
+	api.PATCH("/location/patch/:id", handlers.PatchLocation(db, ss), allow("location", "patch"))
+
+	// resource: Condition
+	api.GET("/condition", handlers.SearchCondition(db, ss), allow("condition", "search"))
+	api.GET("/condition/:id", handlers.GetCondition(db, ss), allow("condition", "get"))
+	api.POST("/condition/create", handlers.CreateCondition(db, ss), allow("condition", "create"))
+	api.POST("/condition/update/:id", handlers.UpdateCondition(db, ss), allow("condition", "put"))
+	api.PATCH("/condition/patch/:id", handlers.PatchCondition(db, ss), allow("condition", "patch"))
+
+	// resource: Observation
+	api.GET("/observation", handlers.SearchObservation(db, ss), allow("observation", "search"))
+	api.GET("/observation/:id", handlers.GetObservation(db, ss), allow("observation", "get"))
+	api.POST("/observation/create", handlers.CreateObservation(db, ss), allow("observation", "create"))
+	api.POST("/observation/vital-signs", handlers.CreateVitalSigns(db, ss), allow("observation", "create"))
+	api.POST("/observation/update/:id", handlers.UpdateObservation(db, ss), allow("observation", "put"))
+	api.PATCH("/observation/patch/:id", handlers.PatchObservation(db, ss), allow("observation", "patch"))
+
+	// resource: Procedure
+	api.GET("/procedure", handlers.SearchProcedure(db, ss), allow("procedure", "search"))
+	api.GET("/procedure/:id", handlers.GetProcedure(db, ss), allow("procedure", "get"))
+	api.POST("/procedure/create", handlers.CreateProcedure(db, ss), allow("procedure", "create"))
+	api.POST("/procedure/update/:id", handlers.UpdateProcedure(db, ss), allow("procedure", "put"))
+	api.PATCH("/procedure/patch/:id", handlers.PatchProcedure(db, ss), allow("procedure", "patch"))
+
+	// resource: Medication, MedicationRequest, MedicationDispense
+	api.GET("/medication/:id", handlers.GetMedication(db, ss), allow("medication", "get"))
+	api.POST("/medication/create", handlers.CreateMedication(db, ss), allow("medication", "create"))
+	api.POST("/medication/update/:id", handlers.UpdateMedication(db, ss), allow("medication", "put"))
+	api.PATCH("/medication/patch/:id", handlers.PatchMedication(db, ss), allow("medication", "patch"))
+	api.GET("/medicationrequest", handlers.SearchMedicationRequest(db, ss), allow("medication_request", "search"))
+	api.GET("/medicationrequest/:id", handlers.GetMedicationRequest(db, ss), allow("medication_request", "get"))
+	api.POST("/medicationrequest/create", handlers.CreateMedicationRequest(db, ss), allow("medication_request", "create"))
+	api.POST("/medicationrequest/update/:id", handlers.UpdateMedicationRequest(db, ss), allow("medication_request", "put"))
+	api.PATCH("/medicationrequest/patch/:id", handlers.PatchMedicationRequest(db, ss), allow("medication_request", "patch"))
+	api.GET("/medicationdispense", handlers.SearchMedicationDispense(db, ss), allow("medication_dispense", "search"))
+	api.GET("/medicationdispense/:id", handlers.GetMedicationDispense(db, ss), allow("medication_dispense", "get"))
+	api.POST("/medicationdispense/create", handlers.CreateMedicationDispense(db, ss), allow("medication_dispense", "create"))
+	api.POST("/medicationdispense/update/:id", handlers.UpdateMedicationDispense(db, ss), allow("medication_dispense", "put"))
+	api.PATCH("/medicationdispense/patch/:id", handlers.PatchMedicationDispense(db, ss), allow("medication_dispense", "patch"))
+	api.GET("/encounter/:id/medications", handlers.EncounterMedications(db), allow("medication_request", "list"))
+
+	// resource: Composition
+	api.GET("/composition", handlers.SearchComposition(db, ss), allow("composition", "search"))
+	api.GET("/composition/:id", handlers.GetComposition(db, ss), allow("composition", "get"))
+	api.POST("/composition/create", handlers.CreateComposition(db, ss), allow("composition", "create"))
+	api.POST("/composition/note", handlers.CreateNoteComposition(db, ss), allow("composition", "create"))
+	api.POST("/composition/update/:id", handlers.UpdateComposition(db, ss), allow("composition", "put"))
+
+	// resource: AllergyIntolerance
+	api.GET("/allergyintolerance", handlers.SearchAllergyIntolerance(db, ss), allow("allergy_intolerance", "search"))
+	api.GET("/allergyintolerance/:id", handlers.GetAllergyIntolerance(db, ss), allow("allergy_intolerance", "get"))
+	api.POST("/allergyintolerance/create", handlers.CreateAllergyIntolerance(db, ss), allow("allergy_intolerance", "create"))
+	api.POST("/allergyintolerance/update/:id", handlers.UpdateAllergyIntolerance(db, ss), allow("allergy_intolerance", "put"))
+	api.PATCH("/allergyintolerance/patch/:id", handlers.PatchAllergyIntolerance(db, ss), allow("allergy_intolerance", "patch"))
+
+	// resource: ServiceRequest, Specimen, DiagnosticReport
+	api.GET("/servicerequest", handlers.SearchServiceRequest(db, ss), allow("service_request", "search"))
+	api.GET("/servicerequest/:id", handlers.GetServiceRequest(db, ss), allow("service_request", "get"))
+	api.GET("/servicerequest/:id/report", handlers.ServiceRequestReport(db), allow("service_request", "get"))
+	api.POST("/servicerequest/create", handlers.CreateServiceRequest(db, ss), allow("service_request", "create"))
+	api.POST("/servicerequest/update/:id", handlers.UpdateServiceRequest(db, ss), allow("service_request", "put"))
+	api.PATCH("/servicerequest/patch/:id", handlers.PatchServiceRequest(db, ss), allow("service_request", "patch"))
+	api.GET("/specimen", handlers.SearchSpecimen(db, ss), allow("specimen", "search"))
+	api.GET("/specimen/:id", handlers.GetSpecimen(db, ss), allow("specimen", "get"))
+	api.POST("/specimen/create", handlers.CreateSpecimen(db, ss), allow("specimen", "create"))
+	api.POST("/specimen/update/:id", handlers.UpdateSpecimen(db, ss), allow("specimen", "put"))
+	api.PATCH("/specimen/patch/:id", handlers.PatchSpecimen(db, ss), allow("specimen", "patch"))
+	api.GET("/diagnosticreport", handlers.SearchDiagnosticReport(db, ss), allow("diagnostic_report", "search"))
+	api.GET("/diagnosticreport/:id", handlers.GetDiagnosticReport(db, ss), allow("diagnostic_report", "get"))
+	api.POST("/diagnosticreport/create", handlers.CreateDiagnosticReport(db, ss), allow("diagnostic_report", "create"))
+	api.POST("/diagnosticreport/update/:id", handlers.UpdateDiagnosticReport(db, ss), allow("diagnostic_report", "put"))
+	api.PATCH("/diagnosticreport/patch/:id", handlers.PatchDiagnosticReport(db, ss), allow("diagnostic_report", "patch"))
+
+	// resource: Immunization
+	api.GET("/immunization", handlers.SearchImmunization(db, ss), allow("immunization", "search"))
+	api.GET("/immunization/:id", handlers.GetImmunization(db, ss), allow("immunization", "get"))
+	api.POST("/immunization/create", handlers.CreateImmunization(db, ss), allow("immunization", "create"))
+	api.POST("/immunization/update/:id", handlers.UpdateImmunization(db, ss), allow("immunization", "put"))
+	api.PATCH("/immunization/patch/:id", handlers.PatchImmunization(db, ss), allow("immunization", "patch"))
+	api.GET("/patient/:id/immunizations", handlers.PatientImmunizations(db), allow("immunization", "list"))
 
 	// Get patient & practitioner
-	e.GET("/simrs/v1/patient/:id", handlers.GetPatient(db))
-	e.GET("/simrs/v1/practitioner/:id", handlers.GetPractitioner(db))
+	api.GET("/patient", handlers.SearchPatient(db, ss), allow("Patient", "search"))
+	api.GET("/patient/:id", handlers.GetPatient(db, ss), allow("Patient", "get"))
+	api.GET("/patient/mrn/:mrn", handlers.PatientByMedicalRecord(db), allow("Patient", "get"))
+	api.POST("/patient/create", handlers.CreatePatient(db, ss), allow("Patient", "create"))
+	api.GET("/practitioner", handlers.SearchPractitioner(db, ss), allow("Practitioner", "search"))
+	api.GET("/practitioner/:id", handlers.GetPractitioner(db, ss), allow("Practitioner", "get"))
+
+	// Practitioner roster: staff codes mapped to IHS practitioner IDs
+	api.GET("/practitioner/roster", handlers.ListRoster(db), allow("practitioner_roster", "list"))
+	api.GET("/practitioner/roster/:staff_id", handlers.GetRosterEntry(roster), allow("practitioner_roster", "get"))
+	api.PUT("/practitioner/roster/:staff_id", handlers.PutRosterEntry(db, roster), allow("practitioner_roster", "put"))
+	api.DELETE("/practitioner/roster/:staff_id", handlers.DeleteRosterEntry(db, roster), allow("practitioner_roster", "delete"))
+
+	// Transaction bundle
+	api.POST("/bundle", handlers.SubmitBundle(db, ss), allow("bundle", "transaction"))
 
 	// Credential endpoints
-	e.GET("/simrs/v1/credentials", handlers.ListCredential(db))
-	e.POST("/simrs/v1/credentials", handlers.InsertCredential(db))
-	e.DELETE("/simrs/v1/credentials", handlers.DeleteCredential(db))
+	api.GET("/credentials", handlers.ListCredential(db, keys), allow("credential", "list"))
+	api.POST("/credentials", handlers.InsertCredential(db, cfg, keys, tokens), allow("credential", "create"))
+	api.POST("/credentials/rollback", handlers.RollbackCredential(db, keys, tokens), allow("credential", "rollback"))
+	api.GET("/credentials/:id", handlers.GetCredential(db, keys), allow("credential", "get"))
+	api.POST("/credentials/:id/verify", handlers.VerifyCredential(db, cfg, keys), allow("credential", "verify"))
+	api.POST("/credentials/:id/activate", handlers.ActivateCredential(db, keys, tokens), allow("credential", "activate"))
+	api.DELETE("/credentials/:id", handlers.DeleteCredential(db), allow("credential", "delete"))
+	api.GET("/token/status", handlers.TokenStatus(tokens), allow("token", "get"))
+
+	// API keys
+	api.GET("/api-keys", handlers.ListAPIKeys(db), allow("api_key", "list"))
+	api.POST("/api-keys", handlers.CreateAPIKey(db, policies), allow("api_key", "create"))
+	api.DELETE("/api-keys/:id", handlers.DeleteAPIKey(db), allow("api_key", "delete"))
+
+	// Roles
+	api.GET("/roles", handlers.ListRoles(db), allow("role", "list"))
+	api.PUT("/roles/:name", handlers.PutRole(db, policies), allow("role", "put"))
+	api.DELETE("/roles/:name", handlers.DeleteRole(db, policies), allow("role", "delete"))
 
 	//audit log
-	e.GET("/simrs/v1/audit-logs", handlers.ListAuditLogs(db))
+	api.GET("/audit-logs", handlers.ListAuditLogs(db), allow("audit_log", "list"))
+
+	go func() {
+		if err := e.Start(cfg.Server.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
 
-	e.Logger.Fatal(e.Start(":8080"))
+	stop, stopNotify := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopNotify()
+	<-stop.Done()
+
+	// Let in-flight requests finish, then cancel whatever is still running.
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	cancelBase()
+
+	disconnectCtx, cancelDisconnect := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDisconnect()
+	if err := client.Disconnect(disconnectCtx); err != nil {
+		log.Printf("mongo disconnect: %v", err)
+	}
 }
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/auth"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// Auth authenticates every request with an API key (X-API-Key) or a JWT
+// bearer token (Authorization: Bearer) and puts the caller in the request
+// context. Requests with neither are rejected.
+func Auth(db *mongo.Database, jwts *auth.Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			var principal *utils.Principal
+
+			if key := c.Request().Header.Get(HeaderAPIKey); key != "" {
+				apiKey, err := utils.FindAPIKey(ctx, db, key)
+				if err == mongo.ErrNoDocuments {
+					return unauthorized(c, "Invalid API key")
+				}
+				if err != nil {
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check API key"})
+				}
+				principal = &utils.Principal{
+					Kind:           utils.PrincipalAPIKey,
+					Subject:        apiKey.ID.Hex(),
+					Name:           apiKey.Name,
+					OrganizationID: apiKey.OrganizationID,
+					Roles:          apiKey.Roles,
+				}
+			} else if token, ok := bearerToken(c.Request()); ok {
+				if !jwts.Enabled() {
+					return unauthorized(c, "Bearer tokens are not accepted; use an API key")
+				}
+				p, err := jwts.Verify(token)
+				if err != nil {
+					return unauthorized(c, "Invalid token: "+err.Error())
+				}
+				principal = p
+			} else {
+				return unauthorized(c, "Missing credentials: send X-API-Key or Authorization: Bearer")
+			}
+
+			c.SetRequest(c.Request().WithContext(utils.WithPrincipal(ctx, principal)))
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get(echo.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func unauthorized(c echo.Context, msg string) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="satusehat-gateway"`)
+	return c.JSON(http.StatusUnauthorized, map[string]string{"error": msg})
+}
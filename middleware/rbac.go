@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+// Authorize allows the route only to callers whose roles grant action on
+// resource. It runs after Auth. Denials get a 403 OperationOutcome and are
+// written to the audit log.
+func Authorize(db *mongo.Database, policies *utils.PolicyStore, resource, action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			p := utils.PrincipalFrom(ctx)
+			if p == nil {
+				return unauthorized(c, "Missing credentials: send X-API-Key or Authorization: Bearer")
+			}
+
+			ok, err := policies.Allowed(ctx, p.Roles, resource, action)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError,
+					models.NewOperationOutcome("error", "exception", "Failed to load access policies"))
+			}
+			if ok {
+				return next(c)
+			}
+
+			return Deny(c, db, resource, action, "Not allowed to "+action+" "+resource, nil)
+		}
+	}
+}
+
+// Deny answers 403 with an OperationOutcome and writes the denied attempt to
+// the audit log, with the caller's roles, the request line and details.
+func Deny(c echo.Context, db *mongo.Database, resource, action, msg string, details map[string]interface{}) error {
+	ctx := c.Request().Context()
+	entry := map[string]interface{}{
+		"denied": true,
+		"method": c.Request().Method,
+		"path":   c.Request().URL.Path,
+	}
+	if p := utils.PrincipalFrom(ctx); p != nil {
+		entry["roles"] = p.Roles
+	}
+	for k, v := range details {
+		entry[k] = v
+	}
+	_ = utils.LogAudit(ctx, db, models.AuditLog{
+		Action:     action,
+		Resource:   resource,
+		ResourceID: c.Param("id"),
+		StatusCode: http.StatusForbidden,
+		Details:    entry,
+	})
+	return c.JSON(http.StatusForbidden, models.NewOperationOutcome("error", "forbidden", msg))
+}
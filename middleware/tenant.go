@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/utils"
+)
+
+const (
+	HeaderAPIKey   = "X-API-Key"
+	HeaderTenantID = "X-Tenant-ID"
+)
+
+// Tenant selects the tenant of a request. It runs after Auth: a caller bound
+// to a tenant (an API key, or a JWT with the tenant claim) uses that tenant;
+// otherwise the X-Tenant-ID header is used, then the configured default
+// tenant. Requests without any of them continue without a tenant and are
+// rejected by handlers that need one. An unbound caller may send
+// "X-Tenant-ID: *" to read across tenants where a handler supports it; the
+// request then carries no tenant. A bound caller naming another tenant is
+// denied and audited under its own tenant.
+func Tenant(db *mongo.Database, cfg *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+			tenantID := c.Request().Header.Get(HeaderTenantID)
+
+			if p := utils.PrincipalFrom(ctx); p != nil && p.OrganizationID != "" {
+				if tenantID != "" && tenantID != p.OrganizationID {
+					c.SetRequest(c.Request().WithContext(utils.WithTenant(ctx, p.OrganizationID)))
+					return Deny(c, db, "tenant", "select", "Caller does not belong to tenant "+tenantID,
+						map[string]interface{}{"requested_tenant": tenantID})
+				}
+				tenantID = p.OrganizationID
+			}
+
+			if tenantID == utils.AllTenants {
+				c.SetRequest(c.Request().WithContext(utils.WithAllTenants(ctx)))
+				return next(c)
+			}
+			if tenantID == "" {
+				tenantID = cfg.DefaultTenant
+			}
+			if tenantID != "" {
+				c.SetRequest(c.Request().WithContext(utils.WithTenant(ctx, tenantID)))
+			}
+			return next(c)
+		}
+	}
+}
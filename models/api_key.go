@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey lets a calling system act for one tenant. Only the SHA-256 hash of
+// the key is stored.
+type APIKey struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name           string             `bson:"name" json:"name"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	Roles          []string           `bson:"roles" json:"roles"`
+	KeyHash        string             `bson:"key_hash" json:"-"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	CreatedBy      string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RosterEntry maps one of a tenant's staff codes to the SatuSehat (IHS)
+// practitioner ID found for the staff member's NIK.
+type RosterEntry struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	StaffID        string             `bson:"staff_id" json:"staff_id"`
+	NIK            string             `bson:"nik" json:"nik"`
+	IHSID          string             `bson:"ihs_id,omitempty" json:"ihs_id,omitempty"`
+	Name           string             `bson:"name,omitempty" json:"name,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	UpdatedBy      string             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	// RefreshedAt is the last time the IHS ID was looked up, successfully
+	// or not; LastError says why the last lookup failed.
+	RefreshedAt *time.Time `bson:"refreshed_at,omitempty" json:"refreshed_at,omitempty"`
+	LastError   string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+}
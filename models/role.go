@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permission allows one action on one resource, in the AuditLog
+// Resource/Action vocabulary (e.g. "encounter"/"create", "Patient"/"get").
+// "*" matches any resource or action.
+type Permission struct {
+	Resource string `bson:"resource" json:"resource"`
+	Action   string `bson:"action" json:"action"`
+}
+
+// Role is a named set of permissions granted to API keys and JWT callers.
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []Permission       `bson:"permissions" json:"permissions"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	UpdatedBy   string             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+}
+
+// Allows reports whether the role grants action on resource.
+func (r *Role) Allows(resource, action string) bool {
+	for _, p := range r.Permissions {
+		if (p.Resource == "*" || p.Resource == resource) && (p.Action == "*" || p.Action == action) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+// Package satusehat is a small client for the SatuSehat FHIR R4 API.
+//
+// A Client is cheap to create and safe for concurrent use. All clients built
+// without WithHTTPClient share one tuned transport, so connections to the
+// SatuSehat hosts are pooled across the whole process.
+package satusehat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	contentTypeFHIR      = "application/fhir+json"
+	contentTypeJSONPatch = "application/json-patch+json"
+)
+
+// Endpoint is the FHIR base URL and bearer token a request is sent with.
+type Endpoint struct {
+	BaseURL     string
+	AccessToken string
+}
+
+// EndpointSource resolves the Endpoint for a call, typically by looking up a
+// credential and a valid access token.
+type EndpointSource interface {
+	Endpoint(ctx context.Context) (Endpoint, error)
+}
+
+// EndpointFunc adapts a function to EndpointSource.
+type EndpointFunc func(ctx context.Context) (Endpoint, error)
+
+func (f EndpointFunc) Endpoint(ctx context.Context) (Endpoint, error) { return f(ctx) }
+
+// StaticEndpoint always returns the given base URL and token.
+func StaticEndpoint(baseURL, accessToken string) EndpointSource {
+	return EndpointFunc(func(context.Context) (Endpoint, error) {
+		return Endpoint{BaseURL: baseURL, AccessToken: accessToken}, nil
+	})
+}
+
+// EndpointError reports that the endpoint (usually the access token) could
+// not be resolved, so no request was sent.
+type EndpointError struct {
+	Err error
+}
+
+func (e *EndpointError) Error() string { return "satusehat: resolve endpoint: " + e.Err.Error() }
+func (e *EndpointError) Unwrap() error { return e.Err }
+
+// Response is a raw SatuSehat response. Non-2xx responses are returned as-is
+// (usually an OperationOutcome) rather than as errors.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// OK reports whether the status code is 2xx.
+func (r *Response) OK() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// ID returns the "id" of the returned resource, or "" if there is none.
+func (r *Response) ID() string {
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(r.Body, &res); err != nil {
+		return ""
+	}
+	return res.ID
+}
+
+// Client sends requests to SatuSehat.
+type Client struct {
+	http               *http.Client
+	endpoints          EndpointSource
+	timeout            time.Duration
+	transactionTimeout time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the shared HTTP client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithTimeout bounds every call except Transaction with a context deadline.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithTransactionTimeout bounds Transaction calls, which can take much longer
+// than single-resource calls for large bundles.
+func WithTransactionTimeout(d time.Duration) Option {
+	return func(c *Client) { c.transactionTimeout = d }
+}
+
+var sharedHTTPClient = &http.Client{Transport: NewTransport()}
+
+// NewTransport returns an HTTP transport tuned for a handful of SatuSehat
+// hosts receiving many concurrent requests.
+func NewTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// NewClient returns a client that resolves its base URL and token from src.
+// Every call is bound to the caller's context, so cancelling it aborts the
+// request in flight.
+func NewClient(src EndpointSource, opts ...Option) *Client {
+	c := &Client{
+		http:               sharedHTTPClient,
+		endpoints:          src,
+		timeout:            30 * time.Second,
+		transactionTimeout: 2 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Read fetches resourceType/id.
+func (c *Client) Read(ctx context.Context, resourceType, id string) (*Response, error) {
+	return c.do(ctx, c.timeout, http.MethodGet, resourcePath(resourceType, id), nil, nil, "")
+}
+
+// Create posts a new resource.
+func (c *Client) Create(ctx context.Context, resourceType string, body []byte) (*Response, error) {
+	return c.do(ctx, c.timeout, http.MethodPost, resourcePath(resourceType, ""), nil, body, contentTypeFHIR)
+}
+
+// Update replaces resourceType/id.
+func (c *Client) Update(ctx context.Context, resourceType, id string, body []byte) (*Response, error) {
+	return c.do(ctx, c.timeout, http.MethodPut, resourcePath(resourceType, id), nil, body, contentTypeFHIR)
+}
+
+// Patch applies a JSON Patch document to resourceType/id.
+func (c *Client) Patch(ctx context.Context, resourceType, id string, patch []byte) (*Response, error) {
+	return c.do(ctx, c.timeout, http.MethodPatch, resourcePath(resourceType, id), nil, patch, contentTypeJSONPatch)
+}
+
+// Search runs a FHIR search and returns the Bundle.
+func (c *Client) Search(ctx context.Context, resourceType string, query url.Values) (*Response, error) {
+	return c.do(ctx, c.timeout, http.MethodGet, resourcePath(resourceType, ""), query, nil, "")
+}
+
+// Transaction posts a transaction Bundle to the base URL.
+func (c *Client) Transaction(ctx context.Context, bundle []byte) (*Response, error) {
+	return c.do(ctx, c.transactionTimeout, http.MethodPost, "", nil, bundle, contentTypeFHIR)
+}
+
+func resourcePath(resourceType, id string) string {
+	if id == "" {
+		return "/" + resourceType
+	}
+	return "/" + resourceType + "/" + url.PathEscape(id)
+}
+
+func (c *Client) do(ctx context.Context, timeout time.Duration, method, path string, query url.Values, body []byte, contentType string) (*Response, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ep, err := c.endpoints.Endpoint(ctx)
+	if err != nil {
+		return nil, &EndpointError{Err: err}
+	}
+
+	u := strings.TrimRight(ep.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, fmt.Errorf("satusehat: build %s %s: %w", method, path, err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", contentTypeFHIR)
+	req.Header.Set("Authorization", "Bearer "+ep.AccessToken)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("satusehat: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("satusehat: read %s %s response: %w", method, path, err)
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody}, nil
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+)
+
+const apiKeyPrefix = "sk_"
+
+// GenerateAPIKey returns a new random API key and the hash to store for it.
+func GenerateAPIKey() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex SHA-256 of key. API keys are long random
+// strings, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FindAPIKey looks up a presented API key. It returns mongo.ErrNoDocuments
+// when the key is unknown.
+func FindAPIKey(ctx context.Context, db *mongo.Database, key string) (*models.APIKey, error) {
+	var k models.APIKey
+	err := db.Collection("api_keys").FindOne(ctx, bson.M{"key_hash": HashAPIKey(key)}).Decode(&k)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateAPIKey issues and stores an API key for a tenant. The returned key is
+// the only copy; Mongo keeps its hash.
+func CreateAPIKey(ctx context.Context, db *mongo.Database, name, tenantID string, roles []string, user string) (*models.APIKey, string, error) {
+	key, hash, err := GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey := models.APIKey{
+		Name:           name,
+		OrganizationID: tenantID,
+		Roles:          roles,
+		KeyHash:        hash,
+		CreatedAt:      time.Now(),
+		CreatedBy:      user,
+	}
+	res, err := db.Collection("api_keys").InsertOne(ctx, apiKey)
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey.ID, _ = res.InsertedID.(primitive.ObjectID)
+	return &apiKey, key, nil
+}
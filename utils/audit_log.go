@@ -2,15 +2,26 @@ package utils
 
 import (
 	"context"
-	"satusehat-golang/models"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
 )
 
-func LogAudit(db *mongo.Database, log models.AuditLog) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// LogAudit records an audit entry, scoped to the tenant in ctx and
+// attributed to the authenticated caller in ctx unless the entry names
+// them. The write is detached from ctx's cancellation so an action that
+// already happened upstream is still recorded when the caller goes away;
+// the Mongo client timeout still bounds it.
+func LogAudit(ctx context.Context, db *mongo.Database, log models.AuditLog) error {
+	ctx = context.WithoutCancel(ctx)
+	if log.OrganizationID == "" {
+		log.OrganizationID = TenantFrom(ctx)
+	}
+	if log.User == "" {
+		log.User = UserFrom(ctx)
+	}
 	log.Timestamp = time.Now().Unix() // if using timestamp
 	_, err := db.Collection("audit_logs").InsertOne(ctx, log)
 	return err
@@ -0,0 +1,329 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+)
+
+var (
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrCredentialActive   = errors.New("credential is active")
+	ErrNoPreviousVersion  = errors.New("no previously active credential to roll back to")
+)
+
+// Credential is one version of a tenant's SatuSehat client credential. A
+// tenant can have many versions; exactly one of them is active and used for
+// tokens, the others are kept for rollback.
+type Credential struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	OrganizationID string             `bson:"organization_id"`
+	Version        int                `bson:"version"`
+	Active         bool               `bson:"active"`
+	ClientID       string             `bson:"client_id"`
+	// ClientSecret is only filled in documents written before encryption at
+	// rest; the re-encryption command moves it into ClientSecretEnc.
+	ClientSecret    string             `bson:"client_secret,omitempty"`
+	ClientSecretEnc *encryption.Sealed `bson:"client_secret_enc,omitempty"`
+	// TokenURL overrides the OAuth endpoint of the profile when set.
+	TokenURL    string `bson:"token_url,omitempty"`
+	Environment string `bson:"environment"`
+
+	CreatedAt   time.Time  `bson:"created_at"`
+	CreatedBy   string     `bson:"created_by"`
+	UpdatedAt   time.Time  `bson:"updated_at"`
+	UpdatedBy   string     `bson:"updated_by"`
+	VerifiedAt  *time.Time `bson:"verified_at,omitempty"`
+	ActivatedAt *time.Time `bson:"activated_at,omitempty"`
+}
+
+func credentialAAD(organizationID string) []byte {
+	return []byte("credentials/" + organizationID + "/client_secret")
+}
+
+// Secret returns the decrypted client secret.
+func (c *Credential) Secret(keys *encryption.Keyring) (string, error) {
+	if c.ClientSecretEnc == nil {
+		return c.ClientSecret, nil
+	}
+	secret, err := keys.Open(c.ClientSecretEnc, credentialAAD(c.OrganizationID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// SetSecret encrypts secret into the credential.
+func (c *Credential) SetSecret(keys *encryption.Keyring, secret string) error {
+	sealed, err := keys.Seal([]byte(secret), credentialAAD(c.OrganizationID))
+	if err != nil {
+		return err
+	}
+	c.ClientSecret = ""
+	c.ClientSecretEnc = sealed
+	return nil
+}
+
+// Validate checks the credential before it is stored and returns every
+// problem found. The secret is checked in plaintext, before encryption.
+func (c *Credential) Validate(cfg *config.Config, secret string) []string {
+	var problems []string
+	if c.OrganizationID == "" {
+		problems = append(problems, "organization_id is required")
+	}
+	if strings.TrimSpace(c.ClientID) == "" {
+		problems = append(problems, "client_id is required")
+	}
+	if strings.TrimSpace(secret) == "" {
+		problems = append(problems, "client_secret is required")
+	}
+
+	name := c.Environment
+	if name == "" {
+		name = cfg.SatuSehat.DefaultProfile
+	}
+	profile, ok := cfg.SatuSehat.Profiles[name]
+	if !ok {
+		problems = append(problems, fmt.Sprintf("environment %q is not a known profile", c.Environment))
+	}
+
+	if c.TokenURL != "" {
+		u, err := url.Parse(c.TokenURL)
+		switch {
+		case err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https"):
+			problems = append(problems, fmt.Sprintf("token_url %q is not a valid http(s) URL", c.TokenURL))
+		case u.Scheme != "https":
+			problems = append(problems, "token_url must use https")
+		case ok && !sameHost(c.TokenURL, profile.AuthURL):
+			problems = append(problems, fmt.Sprintf("token_url host %s does not match the %s profile (%s)", u.Host, name, profile.AuthURL))
+		}
+	}
+	return problems
+}
+
+// MaskSecret hides all but the last four characters of a secret.
+func MaskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
+
+// ActiveCredential returns the active credential of a tenant.
+func ActiveCredential(ctx context.Context, db *mongo.Database, tenantID string) (*Credential, error) {
+	var cred Credential
+	err := db.Collection("credentials").FindOne(ctx, bson.M{"organization_id": tenantID, "active": true}).Decode(&cred)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("no active credential for tenant %s: %w", tenantID, ErrCredentialNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// FindCredential returns one credential version of a tenant.
+func FindCredential(ctx context.Context, db *mongo.Database, tenantID string, id primitive.ObjectID) (*Credential, error) {
+	var cred Credential
+	err := db.Collection("credentials").FindOne(ctx, bson.M{"_id": id, "organization_id": tenantID}).Decode(&cred)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// ListCredentials returns every credential version of a tenant, newest first.
+func ListCredentials(ctx context.Context, db *mongo.Database, tenantID string) ([]Credential, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}})
+	cur, err := db.Collection("credentials").Find(ctx, bson.M{"organization_id": tenantID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	creds := []Credential{}
+	if err := cur.All(ctx, &creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// InsertCredentialVersion stores cred as the next version of its tenant,
+// inactive. The version number is retried if another insert races it.
+func InsertCredentialVersion(ctx context.Context, db *mongo.Database, cred *Credential, user string) error {
+	now := time.Now()
+	cred.ID = primitive.NilObjectID
+	cred.Active = false
+	cred.CreatedAt, cred.UpdatedAt = now, now
+	cred.CreatedBy, cred.UpdatedBy = user, user
+
+	for attempt := 0; attempt < 3; attempt++ {
+		var last Credential
+		opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}).SetProjection(bson.M{"version": 1})
+		err := db.Collection("credentials").FindOne(ctx, bson.M{"organization_id": cred.OrganizationID}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		cred.Version = last.Version + 1
+
+		res, err := db.Collection("credentials").InsertOne(ctx, cred)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		cred.ID = res.InsertedID.(primitive.ObjectID)
+		return nil
+	}
+	return errors.New("could not allocate a credential version, try again")
+}
+
+// ActivateCredential makes id the active credential of the tenant and
+// deactivates the others. Both writes run in one transaction, so a failure
+// never leaves the tenant without an active credential.
+func ActivateCredential(ctx context.Context, db *mongo.Database, tenantID string, id primitive.ObjectID, user string) (*Credential, error) {
+	cred, err := FindCredential(ctx, db, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = withTransaction(ctx, db, func(ctx context.Context) error {
+		return switchActiveCredential(ctx, db.Collection("credentials"), tenantID, id, now, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cred.Active = true
+	cred.ActivatedAt = &now
+	cred.UpdatedAt, cred.UpdatedBy = now, user
+	return cred, nil
+}
+
+// switchActiveCredential deactivates the tenant's active credential and
+// activates id. The unique index allows at most one active credential per
+// tenant, so the old one has to go first; if activating id then fails, the
+// old one is restored (outside a transaction nothing else would undo it).
+func switchActiveCredential(ctx context.Context, coll *mongo.Collection, tenantID string, id primitive.ObjectID, now time.Time, user string) error {
+	var previous []Credential
+	cur, err := coll.Find(ctx, bson.M{"organization_id": tenantID, "active": true, "_id": bson.M{"$ne": id}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	if err := cur.All(ctx, &previous); err != nil {
+		return err
+	}
+
+	if len(previous) > 0 {
+		_, err = coll.UpdateMany(ctx,
+			bson.M{"organization_id": tenantID, "active": true, "_id": bson.M{"$ne": id}},
+			bson.M{"$set": bson.M{"active": false, "updated_at": now, "updated_by": user}})
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := coll.UpdateOne(ctx, bson.M{"_id": id, "organization_id": tenantID}, bson.M{"$set": bson.M{
+		"active": true, "activated_at": now, "updated_at": now, "updated_by": user,
+	}})
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrCredentialNotFound
+	}
+	if err != nil && len(previous) > 0 {
+		if _, restoreErr := coll.UpdateByID(context.WithoutCancel(ctx), previous[0].ID, bson.M{"$set": bson.M{"active": true}}); restoreErr != nil {
+			return fmt.Errorf("%w (restoring the previous credential also failed: %v)", err, restoreErr)
+		}
+	}
+	return err
+}
+
+// withTransaction runs fn in a transaction. Standalone servers cannot run
+// transactions; there fn runs on its own and must undo its partial writes.
+func withTransaction(ctx context.Context, db *mongo.Database, fn func(ctx context.Context) error) error {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if transactionsUnsupported(err) {
+		return fn(ctx)
+	}
+	return err
+}
+
+// transactionsUnsupported reports whether err says the server is a
+// standalone, which has no transactions.
+func transactionsUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		// IllegalOperation: "Transaction numbers are only allowed on a replica set member or mongos"
+		return cmdErr.Code == 20 && strings.Contains(cmdErr.Message, "Transaction numbers")
+	}
+	return false
+}
+
+// PreviousCredential returns the most recently activated credential that is
+// no longer active, the target of a rollback.
+func PreviousCredential(ctx context.Context, db *mongo.Database, tenantID string) (*Credential, error) {
+	var cred Credential
+	opts := options.FindOne().SetSort(bson.D{{Key: "activated_at", Value: -1}})
+	filter := bson.M{"organization_id": tenantID, "active": false, "activated_at": bson.M{"$exists": true}}
+	err := db.Collection("credentials").FindOne(ctx, filter, opts).Decode(&cred)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoPreviousVersion
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// MarkCredentialVerified records a successful test exchange.
+func MarkCredentialVerified(ctx context.Context, db *mongo.Database, cred *Credential, user string) error {
+	now := time.Now()
+	_, err := db.Collection("credentials").UpdateByID(ctx, cred.ID, bson.M{"$set": bson.M{
+		"verified_at": now, "updated_at": now, "updated_by": user,
+	}})
+	if err != nil {
+		return err
+	}
+	cred.VerifiedAt = &now
+	cred.UpdatedAt, cred.UpdatedBy = now, user
+	return nil
+}
+
+// DeleteCredentialVersion removes an inactive credential version.
+func DeleteCredentialVersion(ctx context.Context, db *mongo.Database, tenantID string, id primitive.ObjectID) (*Credential, error) {
+	cred, err := FindCredential(ctx, db, tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	if cred.Active {
+		return nil, ErrCredentialActive
+	}
+	if _, err := db.Collection("credentials").DeleteOne(ctx, bson.M{"_id": id, "active": false}); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSwitchActiveCredential(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	previous, target := primitive.NewObjectID(), primitive.NewObjectID()
+	activeNow := func() bson.D {
+		return mtest.CreateCursorResponse(0, "satusehat.credentials", mtest.FirstBatch, bson.D{{Key: "_id", Value: previous}})
+	}
+	updated := func(n int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: n}, bson.E{Key: "nModified", Value: n})
+	}
+
+	mt.Run("activates the target", func(mt *mtest.T) {
+		mt.AddMockResponses(activeNow(), updated(1), updated(1))
+		if err := switchActiveCredential(context.Background(), mt.Coll, testTenant, target, time.Now(), "ops"); err != nil {
+			mt.Fatal(err)
+		}
+	})
+
+	mt.Run("restores the previous credential when activation fails", func(mt *mtest.T) {
+		mt.AddMockResponses(activeNow(), updated(1),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 91, Name: "ShutdownInProgress", Message: "shutting down"}),
+			updated(1))
+		err := switchActiveCredential(context.Background(), mt.Coll, testTenant, target, time.Now(), "ops")
+		if err == nil {
+			mt.Fatal("activation error was swallowed")
+		}
+
+		events := mt.GetAllStartedEvents()
+		last := events[len(events)-1].Command
+		q := last.Lookup("updates").Array().Index(0).Value().Document()
+		if id, _ := q.Lookup("q", "_id").ObjectIDOK(); id != previous {
+			mt.Fatalf("last write targets %v, want the previous credential %v", id, previous)
+		}
+		if active, _ := q.Lookup("u", "$set", "active").BooleanOK(); !active {
+			mt.Fatal("previous credential was not re-activated")
+		}
+	})
+
+	mt.Run("unknown target restores too", func(mt *mtest.T) {
+		mt.AddMockResponses(activeNow(), updated(1), updated(0), updated(1))
+		err := switchActiveCredential(context.Background(), mt.Coll, testTenant, target, time.Now(), "ops")
+		if !errors.Is(err, ErrCredentialNotFound) {
+			mt.Fatalf("error = %v, want ErrCredentialNotFound", err)
+		}
+		if n := len(mt.GetAllStartedEvents()); n != 4 {
+			mt.Fatalf("%d commands, want find, deactivate, activate, restore", n)
+		}
+	})
+}
+
+func TestTransactionsUnsupported(t *testing.T) {
+	standalone := mongo.CommandError{Code: 20, Name: "IllegalOperation",
+		Message: "Transaction numbers are only allowed on a replica set member or mongos"}
+	if !transactionsUnsupported(standalone) {
+		t.Error("standalone error not recognised")
+	}
+	if transactionsUnsupported(mongo.CommandError{Code: 20, Message: "something else"}) {
+		t.Error("unrelated IllegalOperation treated as standalone")
+	}
+	if transactionsUnsupported(errors.New("network")) || transactionsUnsupported(nil) {
+		t.Error("non-command error treated as standalone")
+	}
+}
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+)
+
+// mirrorCollections hold copies of the FHIR resources accepted by SatuSehat.
+var mirrorCollections = []string{"encounters", "locations", "conditions", "observations", "procedures",
+	"medications", "medication_requests", "medication_dispenses", "compositions",
+	"allergy_intolerances", "service_requests", "specimens", "diagnostic_reports",
+	"immunizations", "organizations", "patients"}
+
+// EnsureIndexes creates the indexes the gateway relies on. It is safe to run
+// on every start.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	// Versioned credentials replaced the one-credential-per-tenant index
+	if err := dropIndexIfExists(ctx, db.Collection("credentials"), "organization_id_1"); err != nil {
+		return err
+	}
+
+	indexes := map[string][]mongo.IndexModel{
+		"credentials": {
+			{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// at most one active credential per tenant
+			{Keys: bson.D{{Key: "organization_id", Value: 1}}, Options: options.Index().
+				SetName("organization_id_active").
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"active": true})},
+		},
+		"tokens":               {{Keys: bson.D{{Key: "organization_id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"api_keys":             {{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"medication_requests":  {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "encounter.reference", Value: 1}}}},
+		"medication_dispenses": {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "context.reference", Value: 1}}}},
+		"specimens":            {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "request.reference", Value: 1}}}},
+		"diagnostic_reports":   {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "basedOn.reference", Value: 1}}}},
+		"immunizations":        {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "patient.reference", Value: 1}}}},
+		"organizations":        {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "partOf.reference", Value: 1}}}},
+		"locations":            {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "partOf.reference", Value: 1}}}},
+		"practitioners":        {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "staff_id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		// one patient per medical record number, reserved before SatuSehat is called
+		"patients": {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "medical_record_number", Value: 1}}, Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"medical_record_number": bson.M{"$exists": true}})}},
+		"roles":      {{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		"audit_logs": {{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "timestamp", Value: -1}}}},
+	}
+	// mirror collections are looked up by tenant and FHIR ID
+	for _, coll := range mirrorCollections {
+		indexes[coll] = append(indexes[coll], mongo.IndexModel{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "id", Value: 1}}})
+	}
+	for coll, idx := range indexes {
+		if _, err := db.Collection(coll).Indexes().CreateMany(ctx, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdoptLegacyTenant assigns documents written before multi-tenancy, which
+// have no organization_id, to tenantID. Client secrets already encrypted
+// under the empty tenant are re-sealed for tenantID, since the tenant is
+// part of their encryption context.
+func AdoptLegacyTenant(ctx context.Context, db *mongo.Database, keys *encryption.Keyring, tenantID string) error {
+	filter := bson.M{"organization_id": bson.M{"$exists": false}}
+
+	creds, err := db.Collection("credentials").Find(ctx, bson.M{
+		"organization_id":   bson.M{"$exists": false},
+		"client_secret_enc": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return err
+	}
+	defer creds.Close(ctx)
+	for creds.Next(ctx) {
+		var cred Credential
+		if err := creds.Decode(&cred); err != nil {
+			return err
+		}
+		secret, err := cred.Secret(keys)
+		if err != nil {
+			return fmt.Errorf("credential %s: %w", cred.ID.Hex(), err)
+		}
+		cred.OrganizationID = tenantID
+		if err := cred.SetSecret(keys, secret); err != nil {
+			return fmt.Errorf("credential %s: %w", cred.ID.Hex(), err)
+		}
+		update := bson.M{"$set": bson.M{"organization_id": tenantID, "client_secret_enc": cred.ClientSecretEnc}}
+		if _, err := db.Collection("credentials").UpdateOne(ctx, bson.M{"_id": cred.ID, "organization_id": bson.M{"$exists": false}}, update); err != nil {
+			return err
+		}
+	}
+	if err := creds.Err(); err != nil {
+		return err
+	}
+
+	// Tokens sealed under the empty tenant no longer open once adopted and
+	// are simply fetched again.
+	update := bson.M{"$set": bson.M{"organization_id": tenantID}}
+	for _, coll := range []string{"credentials", "tokens", "encounters", "locations", "audit_logs"} {
+		if _, err := db.Collection(coll).UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropIndexIfExists(ctx context.Context, coll *mongo.Collection, name string) error {
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec.Name == name {
+			_, err := coll.Indexes().DropOne(ctx, name)
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateCredentialVersions turns credentials stored before versioning into
+// version 1 of their tenant, active.
+func MigrateCredentialVersions(ctx context.Context, db *mongo.Database) error {
+	now := time.Now()
+	_, err := db.Collection("credentials").UpdateMany(ctx,
+		bson.M{"active": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"active":       true,
+			"version":      1,
+			"created_at":   now,
+			"created_by":   "migration",
+			"updated_at":   now,
+			"updated_by":   "migration",
+			"activated_at": now,
+		}})
+	return err
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Kinds of OAuth failure, matched with errors.Is.
+var (
+	ErrInvalidClient            = errors.New("invalid client credentials")
+	ErrTokenEndpointUnreachable = errors.New("token endpoint unreachable")
+	ErrMalformedTokenResponse   = errors.New("malformed token response")
+)
+
+// OAuthError describes a failed client-credentials exchange.
+type OAuthError struct {
+	Kind       error  // ErrInvalidClient, ErrTokenEndpointUnreachable or ErrMalformedTokenResponse
+	StatusCode int    // HTTP status from the token endpoint, 0 if none was received
+	Message    string // message reported by the token endpoint, if any
+	Err        error  // underlying transport or decode error, if any
+}
+
+func (e *OAuthError) Error() string {
+	msg := "oauth: " + e.Kind.Error()
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *OAuthError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// TokenCooldownError is returned instead of calling the token endpoint while
+// the TokenManager backs off after failed refreshes.
+type TokenCooldownError struct {
+	Until    time.Time
+	Failures int
+	Err      error // last refresh error
+}
+
+func (e *TokenCooldownError) Error() string {
+	return fmt.Sprintf("token refresh suspended until %s after %d failed attempt(s): %v",
+		e.Until.Format(time.RFC3339), e.Failures, e.Err)
+}
+
+func (e *TokenCooldownError) Unwrap() error { return e.Err }
+
+// oauthErrorMessage extracts the human readable message from a token
+// endpoint error body. SatuSehat answers in several shapes depending on which
+// layer rejected the request.
+func oauthErrorMessage(body []byte) string {
+	var res struct {
+		Error            interface{} `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+		ErrorCode        string      `json:"ErrorCode"`
+		ErrorText        string      `json:"Error"`
+		Fault            struct {
+			FaultString string `json:"faultstring"`
+		} `json:"fault"`
+	}
+	if err := json.Unmarshal(body, &res); err == nil {
+		var parts []string
+		if s, ok := res.Error.(string); ok && s != "" {
+			parts = append(parts, s)
+		}
+		for _, s := range []string{res.ErrorDescription, res.ErrorCode, res.ErrorText, res.Fault.FaultString} {
+			if s != "" {
+				parts = append(parts, s)
+			}
+		}
+		if len(parts) > 0 {
+			return strings.Join(parts, ": ")
+		}
+	}
+
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200] + "..."
+	}
+	return msg
+}
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+)
+
+// policyCacheTTL is how long roles read from Mongo are reused. Changes made
+// through the API take effect at once; direct edits in Mongo within this.
+const policyCacheTTL = 30 * time.Second
+
+// DefaultRoles are created on start when missing. Edited defaults are left
+// alone; unedited ones follow previousDefaults.
+func DefaultRoles() []models.Role {
+	return []models.Role{
+		{
+			Name:        "ops",
+			Description: "Gateway operators: everything, including credentials, API keys, roles and audit logs",
+			Permissions: []models.Permission{{Resource: "*", Action: "*"}},
+		},
+		{
+			Name:        "front-desk",
+			Description: "Registration desk: register, read and search Patient",
+			Permissions: []models.Permission{
+				{Resource: "Patient", Action: "create"},
+				{Resource: "Patient", Action: "get"},
+				{Resource: "Patient", Action: "search"},
+			},
+		},
+		{
+			Name:        "clinical",
+			Description: "Clinical modules: create and patch Encounter, record clinical resources, read what they reference",
+			Permissions: []models.Permission{
+				{Resource: "encounter", Action: "create"},
+				{Resource: "encounter", Action: "patch"},
+				{Resource: "encounter", Action: "get"},
+				{Resource: "Patient", Action: "get"},
+				{Resource: "Patient", Action: "search"},
+				{Resource: "Practitioner", Action: "get"},
+				{Resource: "Practitioner", Action: "search"},
+				{Resource: "practitioner_roster", Action: "get"},
+				{Resource: "practitioner_roster", Action: "list"},
+				{Resource: "location", Action: "get"},
+				{Resource: "location", Action: "search"},
+				{Resource: "location", Action: "list"},
+				{Resource: "organization", Action: "get"},
+				{Resource: "organization", Action: "list"},
+				{Resource: "condition", Action: "*"},
+				{Resource: "observation", Action: "*"},
+				{Resource: "procedure", Action: "*"},
+				{Resource: "medication", Action: "*"},
+				{Resource: "medication_request", Action: "*"},
+				{Resource: "medication_dispense", Action: "*"},
+				{Resource: "composition", Action: "*"},
+				{Resource: "allergy_intolerance", Action: "*"},
+				{Resource: "service_request", Action: "*"},
+				{Resource: "specimen", Action: "*"},
+				{Resource: "diagnostic_report", Action: "*"},
+				{Resource: "immunization", Action: "*"},
+			},
+		},
+	}
+}
+
+// previousDefaults are the permissions a default role had before a release
+// widened it. A role that still has exactly these and was last written by
+// the seeding (updated_by "system") was never edited, so EnsureDefaultRoles
+// brings it up to the current default.
+var previousDefaults = map[string][][]models.Permission{
+	// before Patient registration
+	"front-desk": {{{Resource: "Patient", Action: "get"}, {Resource: "Patient", Action: "search"}}},
+}
+
+// EnsureDefaultRoles inserts the default roles that do not exist yet and
+// upgrades those still matching a previous default.
+func EnsureDefaultRoles(ctx context.Context, db *mongo.Database) error {
+	now := time.Now()
+	for _, role := range DefaultRoles() {
+		for _, old := range previousDefaults[role.Name] {
+			_, err := db.Collection("roles").UpdateOne(ctx,
+				bson.M{"name": role.Name, "permissions": old, "updated_by": "system"},
+				bson.M{"$set": bson.M{
+					"description": role.Description,
+					"permissions": role.Permissions,
+					"updated_at":  now,
+					"updated_by":  "system",
+				}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := db.Collection("roles").UpdateOne(ctx,
+			bson.M{"name": role.Name},
+			bson.M{"$setOnInsert": bson.M{
+				"name":        role.Name,
+				"description": role.Description,
+				"permissions": role.Permissions,
+				"updated_at":  now,
+				"updated_by":  "system",
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PolicyStore answers access checks from the roles collection, cached in
+// memory.
+type PolicyStore struct {
+	db *mongo.Database
+
+	mu       sync.Mutex
+	roles    map[string]*models.Role
+	loadedAt time.Time
+}
+
+func NewPolicyStore(db *mongo.Database) *PolicyStore {
+	return &PolicyStore{db: db}
+}
+
+// Allowed reports whether any of roles grants action on resource.
+func (s *PolicyStore) Allowed(ctx context.Context, roles []string, resource, action string) (bool, error) {
+	all, err := s.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range roles {
+		if role, ok := all[name]; ok && role.Allows(resource, action) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Unknown returns the names in roles that are not defined.
+func (s *PolicyStore) Unknown(ctx context.Context, roles []string) ([]string, error) {
+	all, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, name := range roles {
+		if _, ok := all[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
+
+// Superuser reports whether roles grant every action on every resource.
+func (s *PolicyStore) Superuser(ctx context.Context, roles []string) (bool, error) {
+	return s.Allowed(ctx, roles, "*", "*")
+}
+
+// Ungrantable returns the roles in requested that p may not hand out. A
+// caller can only grant roles it holds itself, unless it holds "*" on "*".
+func (s *PolicyStore) Ungrantable(ctx context.Context, p *Principal, requested []string) ([]string, error) {
+	if p == nil {
+		return requested, nil
+	}
+	super, err := s.Superuser(ctx, p.Roles)
+	if err != nil || super {
+		return nil, err
+	}
+	held := make(map[string]bool, len(p.Roles))
+	for _, name := range p.Roles {
+		held[name] = true
+	}
+	var denied []string
+	for _, name := range requested {
+		if !held[name] {
+			denied = append(denied, name)
+		}
+	}
+	return denied, nil
+}
+
+// GlobalOperator reports whether p may change what applies to every tenant,
+// such as the roles: it must not be bound to a tenant and must hold "*" on
+// "*".
+func (s *PolicyStore) GlobalOperator(ctx context.Context, p *Principal) (bool, error) {
+	if p == nil || p.OrganizationID != "" {
+		return false, nil
+	}
+	return s.Superuser(ctx, p.Roles)
+}
+
+// Invalidate drops the cache so the next check reads the roles again.
+func (s *PolicyStore) Invalidate() {
+	s.mu.Lock()
+	s.roles = nil
+	s.mu.Unlock()
+}
+
+func (s *PolicyStore) load(ctx context.Context) (map[string]*models.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles != nil && time.Since(s.loadedAt) < policyCacheTTL {
+		return s.roles, nil
+	}
+
+	cur, err := s.db.Collection("roles").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Role
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]*models.Role, len(list))
+	for i := range list {
+		roles[list[i].Name] = &list[i]
+	}
+	s.roles = roles
+	s.loadedAt = time.Now()
+	return roles, nil
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func rolesFound() bson.D {
+	perm := func(resource, action string) bson.D {
+		return bson.D{{Key: "resource", Value: resource}, {Key: "action", Value: action}}
+	}
+	return mtest.CreateCursorResponse(0, "satusehat.roles", mtest.FirstBatch,
+		bson.D{{Key: "name", Value: "ops"}, {Key: "permissions", Value: bson.A{perm("*", "*")}}},
+		bson.D{{Key: "name", Value: "front-desk"}, {Key: "permissions", Value: bson.A{perm("Patient", "create")}}},
+		bson.D{{Key: "name", Value: "clinical"}, {Key: "permissions", Value: bson.A{perm("encounter", "*")}}},
+	)
+}
+
+func TestUngrantable(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		requested []string
+		want      []string
+	}{
+		{"superuser grants anything", &Principal{OrganizationID: testTenant, Roles: []string{"ops"}}, []string{"ops", "clinical"}, nil},
+		{"held roles", &Principal{Roles: []string{"front-desk", "clinical"}}, []string{"clinical"}, nil},
+		{"escalation", &Principal{Roles: []string{"front-desk"}}, []string{"front-desk", "ops"}, []string{"ops"}},
+		{"no principal", nil, []string{"front-desk"}, []string{"front-desk"}},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(rolesFound())
+			got, err := NewPolicyStore(mt.DB).Ungrantable(context.Background(), tt.principal, tt.requested)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				mt.Fatalf("Ungrantable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobalOperator(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      bool
+	}{
+		{"unbound superuser", &Principal{Roles: []string{"ops"}}, true},
+		{"tenant-bound superuser", &Principal{OrganizationID: testTenant, Roles: []string{"ops"}}, false},
+		{"unbound without */*", &Principal{Roles: []string{"clinical"}}, false},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(rolesFound())
+			got, err := NewPolicyStore(mt.DB).GlobalOperator(context.Background(), tt.principal)
+			if err != nil {
+				mt.Fatal(err)
+			}
+			if got != tt.want {
+				mt.Fatalf("GlobalOperator = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureDefaultRolesUpgradesFrontDesk(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("unedited front-desk", func(mt *mtest.T) {
+		var replies []bson.D
+		for i := 0; i < len(DefaultRoles())+1; i++ { // one upsert per role, one upgrade
+			replies = append(replies, mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+		}
+		mt.AddMockResponses(replies...)
+		if err := EnsureDefaultRoles(context.Background(), mt.DB); err != nil {
+			mt.Fatal(err)
+		}
+
+		for _, ev := range mt.GetAllStartedEvents() {
+			update := ev.Command.Lookup("updates").Array().Index(0).Value().Document()
+			if _, ok := update.Lookup("q", "permissions").ArrayOK(); !ok {
+				continue
+			}
+			if name, _ := update.Lookup("q", "name").StringValueOK(); name != "front-desk" {
+				mt.Fatalf("upgrade targets role %q", name)
+			}
+			if by, _ := update.Lookup("q", "updated_by").StringValueOK(); by != "system" {
+				mt.Fatalf("upgrade filter updated_by = %q, want only roles the seeding wrote (system)", by)
+			}
+			if old, _ := update.Lookup("q", "permissions").Array().Values(); len(old) != 2 {
+				mt.Fatalf("upgrade matches %d permissions, want the old default's 2", len(old))
+			}
+			if perms, _ := update.Lookup("u", "$set", "permissions").Array().Values(); len(perms) != 3 {
+				mt.Fatalf("upgrade sets %d permissions, want 3 with Patient/create", len(perms))
+			}
+			return
+		}
+		mt.Fatal("no upgrade was sent for front-desk")
+	})
+}
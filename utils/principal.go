@@ -0,0 +1,57 @@
+package utils
+
+import "context"
+
+// Principal kinds.
+const (
+	PrincipalAPIKey = "api_key"
+	PrincipalJWT    = "jwt"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Kind string
+	// Subject identifies the caller: the API key ID or the JWT "sub" claim.
+	Subject string
+	// Name is a readable name: the API key name or the JWT
+	// preferred_username/name claim. It may be empty.
+	Name string
+	// OrganizationID is the tenant the caller is bound to, or "" if it may
+	// select any tenant.
+	OrganizationID string
+	// Roles name the roles whose permissions the caller has.
+	Roles []string
+}
+
+// User returns the name recorded in audit entries for p.
+func (p *Principal) User() string {
+	switch {
+	case p.Kind == PrincipalAPIKey:
+		return "api-key:" + p.Name
+	case p.Name != "":
+		return p.Name
+	}
+	return p.Subject
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the caller carried by ctx, or nil.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// UserFrom returns the audit user name of the caller carried by ctx, or ""
+// if the request is not authenticated.
+func UserFrom(ctx context.Context) string {
+	if p := PrincipalFrom(ctx); p != nil {
+		return p.User()
+	}
+	return ""
+}
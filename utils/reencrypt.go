@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+)
+
+// ReencryptStats counts the documents touched by Reencrypt.
+type ReencryptStats struct {
+	CredentialsEncrypted int
+	CredentialsRewrapped int
+	TokensEncrypted      int
+	TokensRewrapped      int
+	// Documents without organization_id are left alone: the tenant is part
+	// of the encryption context, so they must be assigned one first (see
+	// AdoptLegacyTenant).
+	CredentialsSkipped int
+	TokensSkipped      int
+}
+
+// Reencrypt encrypts plaintext client secrets and access tokens left from
+// before encryption at rest, and re-wraps values sealed with a non-primary
+// master key so retired keys can be removed from the keyring. Documents
+// that have no tenant yet are skipped and counted.
+func Reencrypt(ctx context.Context, db *mongo.Database, keys *encryption.Keyring) (ReencryptStats, error) {
+	var stats ReencryptStats
+
+	creds, err := db.Collection("credentials").Find(ctx, bson.M{})
+	if err != nil {
+		return stats, err
+	}
+	defer creds.Close(ctx)
+	for creds.Next(ctx) {
+		var doc struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Credential `bson:",inline"`
+		}
+		if err := creds.Decode(&doc); err != nil {
+			return stats, err
+		}
+		if doc.OrganizationID == "" {
+			stats.CredentialsSkipped++
+			continue
+		}
+
+		var sealed *encryption.Sealed
+		switch {
+		case doc.ClientSecret != "":
+			if err := doc.SetSecret(keys, doc.ClientSecret); err != nil {
+				return stats, fmt.Errorf("credential %s: %w", doc.ID.Hex(), err)
+			}
+			sealed = doc.ClientSecretEnc
+			stats.CredentialsEncrypted++
+		case doc.ClientSecretEnc != nil && keys.NeedsRewrap(doc.ClientSecretEnc):
+			if sealed, err = keys.Rewrap(doc.ClientSecretEnc); err != nil {
+				return stats, fmt.Errorf("credential %s: %w", doc.ID.Hex(), err)
+			}
+			stats.CredentialsRewrapped++
+		default:
+			continue
+		}
+
+		update := bson.M{
+			"$set":   bson.M{"client_secret_enc": sealed},
+			"$unset": bson.M{"client_secret": ""},
+		}
+		if _, err := db.Collection("credentials").UpdateByID(ctx, doc.ID, update); err != nil {
+			return stats, err
+		}
+	}
+	if err := creds.Err(); err != nil {
+		return stats, err
+	}
+
+	tokens, err := db.Collection("tokens").Find(ctx, bson.M{})
+	if err != nil {
+		return stats, err
+	}
+	defer tokens.Close(ctx)
+	for tokens.Next(ctx) {
+		var doc struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Token `bson:",inline"`
+		}
+		if err := tokens.Decode(&doc); err != nil {
+			return stats, err
+		}
+		if doc.OrganizationID == "" {
+			stats.TokensSkipped++
+			continue
+		}
+
+		var sealed *encryption.Sealed
+		switch {
+		case doc.AccessToken != "":
+			stored, err := doc.Token.sealed(keys)
+			if err != nil {
+				return stats, fmt.Errorf("token %s: %w", doc.ID.Hex(), err)
+			}
+			sealed = stored.AccessTokenEnc
+			stats.TokensEncrypted++
+		case doc.AccessTokenEnc != nil && keys.NeedsRewrap(doc.AccessTokenEnc):
+			if sealed, err = keys.Rewrap(doc.AccessTokenEnc); err != nil {
+				return stats, fmt.Errorf("token %s: %w", doc.ID.Hex(), err)
+			}
+			stats.TokensRewrapped++
+		default:
+			continue
+		}
+
+		update := bson.M{
+			"$set":   bson.M{"access_token_enc": sealed},
+			"$unset": bson.M{"access_token": ""},
+		}
+		if _, err := db.Collection("tokens").UpdateByID(ctx, doc.ID, update); err != nil {
+			return stats, err
+		}
+	}
+	return stats, tokens.Err()
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+)
+
+// sentSecret returns the client_secret_enc $set by the first update command
+// sent to credentials.
+func sentSecret(mt *mtest.T) *encryption.Sealed {
+	mt.Helper()
+	for _, ev := range mt.GetAllStartedEvents() {
+		if ev.CommandName != "update" {
+			continue
+		}
+		raw := ev.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set", "client_secret_enc")
+		var sealed encryption.Sealed
+		if err := raw.Unmarshal(&sealed); err != nil {
+			mt.Fatal(err)
+		}
+		return &sealed
+	}
+	mt.Fatal("no update was sent")
+	return nil
+}
+
+func TestReencryptSkipsLegacyTenant(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("credentials without organization_id", func(mt *mtest.T) {
+		keys := testKeyring(mt.T)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "satusehat.credentials", mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "client_id", Value: "legacy"}, {Key: "client_secret", Value: "old"}},
+				bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "organization_id", Value: testTenant}, {Key: "client_secret", Value: "s3cret"}},
+			),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateCursorResponse(0, "satusehat.tokens", mtest.FirstBatch),
+		)
+
+		stats, err := Reencrypt(context.Background(), mt.DB, keys)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if stats.CredentialsEncrypted != 1 || stats.CredentialsSkipped != 1 {
+			mt.Fatalf("stats = %+v, want 1 encrypted and 1 skipped", stats)
+		}
+		secret, err := keys.Open(sentSecret(mt), credentialAAD(testTenant))
+		if err != nil || string(secret) != "s3cret" {
+			mt.Fatalf("stored secret = %q, %v", secret, err)
+		}
+	})
+}
+
+func TestAdoptLegacyTenantReseals(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("secret sealed under the empty tenant", func(mt *mtest.T) {
+		keys := testKeyring(mt.T)
+		legacy := Credential{ID: primitive.NewObjectID(), ClientID: "legacy"}
+		if err := legacy.SetSecret(keys, "s3cret"); err != nil {
+			mt.Fatal(err)
+		}
+		doc, err := bson.Marshal(legacy)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		var d bson.D
+		if err := bson.Unmarshal(doc, &d); err != nil {
+			mt.Fatal(err)
+		}
+		// Legacy documents have no organization_id field at all
+		for i, e := range d {
+			if e.Key == "organization_id" {
+				d = append(d[:i], d[i+1:]...)
+				break
+			}
+		}
+
+		replies := []bson.D{
+			mtest.CreateCursorResponse(0, "satusehat.credentials", mtest.FirstBatch, d),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		}
+		for i := 0; i < 5; i++ { // one UpdateMany per collection
+			replies = append(replies, mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+		}
+		mt.AddMockResponses(replies...)
+
+		if err := AdoptLegacyTenant(context.Background(), mt.DB, keys, testTenant); err != nil {
+			mt.Fatal(err)
+		}
+		secret, err := keys.Open(sentSecret(mt), credentialAAD(testTenant))
+		if err != nil || string(secret) != "s3cret" {
+			mt.Fatalf("re-sealed secret = %q, %v; want it readable under the new tenant", secret, err)
+		}
+	})
+}
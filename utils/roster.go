@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+)
+
+const (
+	// PractitionerNIKSystem is the SatuSehat identifier system for a
+	// practitioner's NIK.
+	PractitionerNIKSystem = "https://fhir.kemkes.go.id/id/nik"
+
+	// rosterCheckInterval is how often the roster looks for stale entries.
+	rosterCheckInterval = 10 * time.Minute
+	// rosterBatch bounds the lookups made per check.
+	rosterBatch = 100
+)
+
+var (
+	ErrStaffNotFound        = errors.New("staff code is not in the practitioner roster")
+	ErrPractitionerNotFound = errors.New("no SatuSehat practitioner has this NIK")
+)
+
+// PractitionerMatch is a practitioner found by a SatuSehat search.
+type PractitionerMatch struct {
+	IHSID string `json:"ihs_id"`
+	Name  string `json:"name,omitempty"`
+}
+
+// ParsePractitioners extracts the matches from a Practitioner searchset
+// Bundle.
+func ParsePractitioners(bundle []byte) ([]PractitionerMatch, error) {
+	var b struct {
+		Entry []struct {
+			Resource struct {
+				ID   string `json:"id"`
+				Name []struct {
+					Text   string   `json:"text"`
+					Family string   `json:"family"`
+					Given  []string `json:"given"`
+				} `json:"name"`
+			} `json:"resource"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return nil, err
+	}
+	matches := []PractitionerMatch{}
+	for _, e := range b.Entry {
+		m := PractitionerMatch{IHSID: e.Resource.ID}
+		if len(e.Resource.Name) > 0 {
+			n := e.Resource.Name[0]
+			m.Name = n.Text
+			if m.Name == "" {
+				m.Name = strings.TrimSpace(strings.Join(append(n.Given, n.Family), " "))
+			}
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+// Roster keeps the practitioners collection, which maps each tenant's staff
+// codes to IHS practitioner IDs. An entry is looked up in SatuSehat when it
+// is first used and again once it is older than the refresh interval.
+type Roster struct {
+	db       *mongo.Database
+	ss       *satusehat.Client
+	interval time.Duration
+}
+
+func NewRoster(db *mongo.Database, ss *satusehat.Client, interval time.Duration) *Roster {
+	return &Roster{db: db, ss: ss, interval: interval}
+}
+
+func (r *Roster) coll() *mongo.Collection {
+	return r.db.Collection("practitioners")
+}
+
+// LookupNIK finds the practitioner with the given NIK in SatuSehat, for the
+// tenant in ctx.
+func (r *Roster) LookupNIK(ctx context.Context, nik string) (*PractitionerMatch, error) {
+	resp, err := r.ss.Search(ctx, "Practitioner", url.Values{"identifier": {PractitionerNIKSystem + "|" + nik}})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK() {
+		return nil, fmt.Errorf("SatuSehat practitioner search returned %d", resp.StatusCode)
+	}
+	matches, err := ParsePractitioners(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, ErrPractitionerNotFound
+	}
+	return &matches[0], nil
+}
+
+// Put adds or changes the NIK of a staff code and looks it up right away.
+// The entry is stored even if the lookup fails; the failure is recorded in
+// LastError and returned.
+func (r *Roster) Put(ctx context.Context, staffID, nik, user string) (*models.RosterEntry, error) {
+	tenantID := TenantFrom(ctx)
+	if tenantID == "" {
+		return nil, ErrNoTenant
+	}
+	now := time.Now()
+	filter := bson.M{"organization_id": tenantID, "staff_id": staffID}
+
+	// A new NIK invalidates the IHS ID found for the old one
+	var entry models.RosterEntry
+	err := r.coll().FindOneAndUpdate(ctx,
+		bson.M{"organization_id": tenantID, "staff_id": staffID, "nik": bson.M{"$ne": nik}},
+		bson.M{"$unset": bson.M{"ihs_id": "", "name": "", "refreshed_at": "", "last_error": ""}},
+	).Decode(&entry)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	err = r.coll().FindOneAndUpdate(ctx, filter,
+		bson.M{
+			"$set":         bson.M{"nik": nik, "updated_at": now, "updated_by": user},
+			"$setOnInsert": bson.M{"created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	err = r.refresh(ctx, &entry)
+	return &entry, err
+}
+
+// Find returns the roster entry of a staff code without looking it up.
+func (r *Roster) Find(ctx context.Context, staffID string) (*models.RosterEntry, error) {
+	var entry models.RosterEntry
+	err := r.coll().FindOne(ctx, bson.M{"organization_id": TenantFrom(ctx), "staff_id": staffID}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrStaffNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Resolve returns the roster entry of a staff code, looking up its IHS ID
+// first if it has none yet.
+func (r *Roster) Resolve(ctx context.Context, staffID string) (*models.RosterEntry, error) {
+	entry, err := r.Find(ctx, staffID)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IHSID == "" {
+		if err := r.refresh(ctx, entry); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+// Delete removes a staff code from the roster.
+func (r *Roster) Delete(ctx context.Context, staffID string) error {
+	res, err := r.coll().DeleteOne(ctx, bson.M{"organization_id": TenantFrom(ctx), "staff_id": staffID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrStaffNotFound
+	}
+	return nil
+}
+
+// refresh looks up entry's NIK and stores the result in entry and Mongo. A
+// failed lookup keeps the IHS ID found before.
+func (r *Roster) refresh(ctx context.Context, entry *models.RosterEntry) error {
+	match, lookupErr := r.LookupNIK(ctx, entry.NIK)
+
+	now := time.Now()
+	entry.RefreshedAt = &now
+	set := bson.M{"refreshed_at": now}
+	unset := bson.M{}
+	if lookupErr != nil {
+		entry.LastError = lookupErr.Error()
+		set["last_error"] = entry.LastError
+	} else {
+		entry.IHSID, entry.Name, entry.LastError = match.IHSID, match.Name, ""
+		set["ihs_id"], set["name"] = match.IHSID, match.Name
+		unset["last_error"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if _, err := r.coll().UpdateByID(context.WithoutCancel(ctx), entry.ID, update); err != nil {
+		return err
+	}
+	return lookupErr
+}
+
+// Run refreshes stale roster entries of every tenant until ctx is
+// cancelled.
+func (r *Roster) Run(ctx context.Context) {
+	ticker := time.NewTicker(rosterCheckInterval)
+	defer ticker.Stop()
+	for {
+		r.refreshStale(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Roster) refreshStale(ctx context.Context) {
+	cutoff := time.Now().Add(-r.interval)
+	cur, err := r.coll().Find(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"refreshed_at": bson.M{"$exists": false}},
+			bson.M{"refreshed_at": bson.M{"$lt": cutoff}},
+		}},
+		options.Find().SetSort(bson.D{{Key: "refreshed_at", Value: 1}}).SetLimit(rosterBatch),
+	)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("practitioner roster: %v", err)
+		}
+		return
+	}
+	var entries []models.RosterEntry
+	if err := cur.All(ctx, &entries); err != nil {
+		log.Printf("practitioner roster: %v", err)
+		return
+	}
+
+	for i := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		tenantCtx := WithTenant(ctx, entries[i].OrganizationID)
+		if err := r.refresh(tenantCtx, &entries[i]); err != nil {
+			log.Printf("practitioner roster: tenant %s staff %s: %v", entries[i].OrganizationID, entries[i].StaffID, err)
+		}
+	}
+}
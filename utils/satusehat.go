@@ -3,90 +3,155 @@ package utils
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
 )
 
-type Credential struct {
-	ClientID     string `bson:"client_id"`
-	ClientSecret string `bson:"client_secret"`
-	TokenURL     string `bson:"token_url"`
-}
-
 type Token struct {
-	AccessToken string    `bson:"access_token"`
-	Expiry      time.Time `bson:"expiry"`
+	OrganizationID string `bson:"organization_id"`
+	// AccessToken is kept in memory only; in MongoDB it lives encrypted in
+	// AccessTokenEnc, except in documents written before encryption at rest.
+	AccessToken    string             `bson:"access_token,omitempty"`
+	AccessTokenEnc *encryption.Sealed `bson:"access_token_enc,omitempty"`
+	Expiry         time.Time          `bson:"expiry"`
+	Environment    string             `bson:"environment"`
 }
 
-func GetValidToken(db *mongo.Database) (string, error) {
-	var token Token
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func tokenAAD(organizationID string) []byte {
+	return []byte("tokens/" + organizationID + "/access_token")
+}
 
-	// Find the current token
-	err := db.Collection("tokens").FindOne(ctx, bson.M{}).Decode(&token)
-	if err != nil && err != mongo.ErrNoDocuments {
-		return "", err
+// decrypt fills AccessToken from AccessTokenEnc.
+func (t *Token) decrypt(keys *encryption.Keyring) error {
+	if t.AccessTokenEnc == nil {
+		return nil
 	}
+	token, err := keys.Open(t.AccessTokenEnc, tokenAAD(t.OrganizationID))
+	if err != nil {
+		return err
+	}
+	t.AccessToken = string(token)
+	return nil
+}
 
-	// Check if expired or not found
-	if token.AccessToken == "" || time.Now().After(token.Expiry.Add(-10*time.Second)) {
-		// Get a new token
-		newToken, expiry, err := GenerateNewToken(db) // Implement this function!
-		if err != nil {
-			return "", err
-		}
-
-		// Save to MongoDB
-		token = Token{AccessToken: newToken, Expiry: expiry}
-		_, err = db.Collection("tokens").ReplaceOne(ctx, bson.M{}, token, options.Replace().SetUpsert(true))
-		if err != nil {
-			return "", err
-		}
+// sealed returns the copy of t to store in MongoDB.
+func (t Token) sealed(keys *encryption.Keyring) (Token, error) {
+	enc, err := keys.Seal([]byte(t.AccessToken), tokenAAD(t.OrganizationID))
+	if err != nil {
+		return Token{}, err
 	}
+	t.AccessToken = ""
+	t.AccessTokenEnc = enc
+	return t, nil
+}
 
-	return token.AccessToken, nil
+// TokenResponse is a successful client-credentials exchange.
+type TokenResponse struct {
+	AccessToken      string
+	ExpiresIn        time.Duration
+	Expiry           time.Time
+	OrganizationName string
+	ApplicationName  string
+	DeveloperEmail   string
 }
 
-func GenerateNewToken(db *mongo.Database) (string, time.Time, error) {
-	// Get client_id, client_secret from MongoDB (credentials)
-	var cred Credential
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err := db.Collection("credentials").FindOne(ctx, bson.M{}).Decode(&cred)
+func GenerateNewToken(ctx context.Context, cred Credential, profile config.Profile) (string, time.Time, error) {
+	res, err := ExchangeToken(ctx, cred, profile)
 	if err != nil {
 		return "", time.Time{}, err
 	}
+	return res.AccessToken, res.Expiry, nil
+}
+
+// ExchangeToken performs the client-credentials exchange for cred against
+// the OAuth endpoint of profile.
+func ExchangeToken(ctx context.Context, cred Credential, profile config.Profile) (*TokenResponse, error) {
+	tokenURL := cred.TokenURL
+	if tokenURL == "" {
+		tokenURL = profile.TokenURL()
+	} else if !sameHost(tokenURL, profile.AuthURL) {
+		return nil, fmt.Errorf("token_url %s does not belong to the %s profile", tokenURL, profile.Name)
+	}
 
 	// Prepare POST request to token_url
 	form := url.Values{}
 	form.Add("client_id", cred.ClientID)
 	form.Add("client_secret", cred.ClientSecret)
 	form.Add("grant_type", "client_credentials")
-	req, _ := http.NewRequest("POST", cred.TokenURL, strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	// Make the request
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", time.Time{}, err
+		return nil, &OAuthError{Kind: ErrTokenEndpointUnreachable, Err: err}
 	}
 	defer resp.Body.Close()
 
-	// Parse response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &OAuthError{Kind: ErrTokenEndpointUnreachable, StatusCode: resp.StatusCode, Err: err}
+	}
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, &OAuthError{Kind: ErrTokenEndpointUnreachable, StatusCode: resp.StatusCode, Message: oauthErrorMessage(body)}
+	case resp.StatusCode >= 400:
+		return nil, &OAuthError{Kind: ErrInvalidClient, StatusCode: resp.StatusCode, Message: oauthErrorMessage(body)}
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, &OAuthError{Kind: ErrMalformedTokenResponse, StatusCode: resp.StatusCode, Message: oauthErrorMessage(body)}
+	}
+
+	// Parse response; SatuSehat sends expires_in as a string
 	var result struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
+		AccessToken      string      `json:"access_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		OrganizationName string      `json:"organization_name"`
+		ApplicationName  string      `json:"application_name"`
+		DeveloperEmail   string      `json:"developer.email"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, &OAuthError{Kind: ErrMalformedTokenResponse, StatusCode: resp.StatusCode, Err: err}
+	}
+	expiresIn, err := result.ExpiresIn.Int64()
+	if result.AccessToken == "" || err != nil || expiresIn <= 0 {
+		return nil, &OAuthError{
+			Kind:       ErrMalformedTokenResponse,
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("missing access_token or invalid expires_in %q", result.ExpiresIn),
+		}
 	}
-	json.NewDecoder(resp.Body).Decode(&result)
 
 	// Calculate expiry
-	expiry := time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
-	return result.AccessToken, expiry, nil
+	lifetime := time.Duration(expiresIn) * time.Second
+	return &TokenResponse{
+		AccessToken:      result.AccessToken,
+		ExpiresIn:        lifetime,
+		Expiry:           time.Now().Add(lifetime),
+		OrganizationName: result.OrganizationName,
+		ApplicationName:  result.ApplicationName,
+		DeveloperEmail:   result.DeveloperEmail,
+	}, nil
+}
+
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host)
 }
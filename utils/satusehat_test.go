@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+)
+
+func testProfile(authURL string) config.Profile {
+	return config.Profile{Name: "test", AuthURL: authURL, BaseURL: authURL + "/fhir-r4/v1"}
+}
+
+func TestExchangeToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantKind error // nil means success
+		wantMsg  string
+	}{
+		{"success", http.StatusOK, `{"access_token":"abc","expires_in":"3599","organization_name":"RS Test"}`, nil, ""},
+		{"numeric expires_in", http.StatusOK, `{"access_token":"abc","expires_in":3599}`, nil, ""},
+		{"invalid client", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"Client credentials are invalid"}`, ErrInvalidClient, "invalid_client: Client credentials are invalid"},
+		{"apigee fault", http.StatusUnauthorized, `{"fault":{"faultstring":"Invalid client identifier"}}`, ErrInvalidClient, "Invalid client identifier"},
+		{"server error", http.StatusServiceUnavailable, `upstream down`, ErrTokenEndpointUnreachable, "upstream down"},
+		{"rate limited", http.StatusTooManyRequests, `{"ErrorCode":"rate"}`, ErrTokenEndpointUnreachable, "rate"},
+		{"not json", http.StatusOK, `<html>`, ErrMalformedTokenResponse, ""},
+		{"missing token", http.StatusOK, `{"expires_in":"3599"}`, ErrMalformedTokenResponse, "missing access_token"},
+		{"zero lifetime", http.StatusOK, `{"access_token":"abc","expires_in":"0"}`, ErrMalformedTokenResponse, "invalid expires_in"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil || r.PostForm.Get("client_id") != "client" || r.PostForm.Get("client_secret") != "secret" {
+					t.Errorf("form = %v, want the client credentials", r.PostForm)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			res, err := ExchangeToken(context.Background(), Credential{ClientID: "client", ClientSecret: "secret"}, testProfile(srv.URL))
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Fatalf("ExchangeToken: %v", err)
+				}
+				if res.AccessToken != "abc" || res.ExpiresIn != 3599*time.Second {
+					t.Errorf("response = %+v", res)
+				}
+				return
+			}
+
+			var oauthErr *OAuthError
+			if !errors.As(err, &oauthErr) {
+				t.Fatalf("error = %v, want an OAuthError", err)
+			}
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("kind = %v, want %v", oauthErr.Kind, tt.wantKind)
+			}
+			if oauthErr.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", oauthErr.StatusCode, tt.status)
+			}
+			if tt.wantMsg != "" && !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error %q does not carry %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestExchangeTokenUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := ExchangeToken(context.Background(), Credential{ClientID: "client"}, testProfile(srv.URL))
+	if !errors.Is(err, ErrTokenEndpointUnreachable) {
+		t.Fatalf("error = %v, want ErrTokenEndpointUnreachable", err)
+	}
+}
+
+func TestExchangeTokenForeignTokenURL(t *testing.T) {
+	cred := Credential{ClientID: "client", TokenURL: "https://elsewhere.example/accesstoken"}
+	if _, err := ExchangeToken(context.Background(), cred, testProfile("https://auth.example")); err == nil {
+		t.Fatal("token_url on another host was accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"errors"
+)
+
+// A tenant is one facility with its own SatuSehat organization ID and
+// credential. The tenant ID is that organization ID.
+
+// ErrNoTenant is returned when a request has not selected a tenant.
+var ErrNoTenant = errors.New("no tenant selected: send X-Tenant-ID or an API key")
+
+// AllTenants is the X-Tenant-ID value with which a caller not bound to a
+// tenant asks to read across every tenant, where a handler allows it.
+const AllTenants = "*"
+
+type tenantKey struct{}
+
+type allTenantsKey struct{}
+
+// WithTenant returns a context carrying the tenant ID.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFrom returns the tenant ID carried by ctx, or "".
+func TenantFrom(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+	return id
+}
+
+// WithAllTenants returns a context marking a request that explicitly asked
+// to read across every tenant.
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsKey{}, true)
+}
+
+// AllTenantsFrom reports whether ctx carries an explicit cross-tenant read.
+func AllTenantsFrom(ctx context.Context) bool {
+	all, _ := ctx.Value(allTenantsKey{}).(bool)
+	return all
+}
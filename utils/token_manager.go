@@ -0,0 +1,369 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+	"github.com/jaisyullah/satusehat-be-golang/models"
+	"github.com/jaisyullah/satusehat-be-golang/satusehat"
+)
+
+const (
+	// tokenExpirySkew keeps a token from being handed out just before it expires.
+	tokenExpirySkew = 10 * time.Second
+	// tokenRefreshInterval is how often the background refresher looks for
+	// tokens that are about to expire.
+	tokenRefreshInterval = 30 * time.Second
+
+	// Failed OAuth calls are retried with exponential backoff starting at
+	// tokenBackoffBase and capped at tokenBackoffMax. After
+	// tokenCooldownAfter consecutive failures the token endpoint is left alone
+	// for tokenCooldown.
+	tokenBackoffBase   = time.Second
+	tokenBackoffMax    = time.Minute
+	tokenCooldownAfter = 5
+	tokenCooldown      = 5 * time.Minute
+)
+
+// TokenStatus is the externally visible token state of one tenant. It never
+// contains the token itself.
+type TokenStatus struct {
+	Environment      string     `json:"environment,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	LastRefreshAt    *time.Time `json:"last_refresh_at,omitempty"`
+	LastRefreshError string     `json:"last_refresh_error,omitempty"`
+	LastErrorAt      *time.Time `json:"last_error_at,omitempty"`
+	Failures         int        `json:"consecutive_failures,omitempty"`
+	NextAttemptAt    *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+type tokenResult struct {
+	token   Token
+	profile config.Profile
+}
+
+// tenantToken is the cached token and refresh state of one tenant.
+type tenantToken struct {
+	cached      *tokenResult
+	lastRefresh time.Time
+	lastErr     error
+	lastErrAt   time.Time
+	failures    int
+	nextAttempt time.Time
+}
+
+// TokenManager hands out SatuSehat access tokens per tenant from an
+// in-process cache in front of the Mongo tokens collection. Concurrent misses
+// for a tenant share a single refresh, so only one OAuth call happens per
+// expiry, and Run refreshes tokens in the background before they expire.
+type TokenManager struct {
+	db    *mongo.Database
+	cfg   *config.Config
+	keys  *encryption.Keyring
+	group singleflight.Group
+
+	mu      sync.RWMutex
+	tenants map[string]*tenantToken
+}
+
+func NewTokenManager(db *mongo.Database, cfg *config.Config, keys *encryption.Keyring) *TokenManager {
+	return &TokenManager{db: db, cfg: cfg, keys: keys, tenants: map[string]*tenantToken{}}
+}
+
+// Token returns a valid access token for the tenant in ctx and the
+// environment profile it belongs to.
+func (m *TokenManager) Token(ctx context.Context) (string, config.Profile, error) {
+	tenantID := TenantFrom(ctx)
+	if tenantID == "" {
+		return "", config.Profile{}, ErrNoTenant
+	}
+	res, err := m.get(ctx, tenantID, time.Now().Add(tokenExpirySkew))
+	if err != nil {
+		return "", config.Profile{}, err
+	}
+	return res.token.AccessToken, res.profile, nil
+}
+
+// Endpoint implements satusehat.EndpointSource.
+func (m *TokenManager) Endpoint(ctx context.Context) (satusehat.Endpoint, error) {
+	token, profile, err := m.Token(ctx)
+	if err != nil {
+		return satusehat.Endpoint{}, err
+	}
+	return satusehat.Endpoint{BaseURL: profile.BaseURL, AccessToken: token}, nil
+}
+
+// Invalidate drops the cached and stored token of a tenant, e.g. after its
+// credential has changed.
+func (m *TokenManager) Invalidate(ctx context.Context, tenantID string) error {
+	m.mu.Lock()
+	delete(m.tenants, tenantID)
+	m.mu.Unlock()
+	_, err := m.db.Collection("tokens").DeleteMany(ctx, bson.M{"organization_id": tenantID})
+	return err
+}
+
+// Status reports the token state of every tenant seen since startup.
+func (m *TokenManager) Status() map[string]TokenStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]TokenStatus, len(m.tenants))
+	for id, t := range m.tenants {
+		out[id] = t.status()
+	}
+	return out
+}
+
+func (t *tenantToken) status() TokenStatus {
+	var st TokenStatus
+	if t.cached != nil {
+		expiresAt := t.cached.token.Expiry
+		st.Environment = t.cached.profile.Name
+		st.ExpiresAt = &expiresAt
+	}
+	if !t.lastRefresh.IsZero() {
+		lastRefresh := t.lastRefresh
+		st.LastRefreshAt = &lastRefresh
+	}
+	if t.lastErr != nil {
+		lastErrAt := t.lastErrAt
+		st.LastRefreshError = t.lastErr.Error()
+		st.LastErrorAt = &lastErrAt
+	}
+	if t.failures > 0 {
+		nextAttempt := t.nextAttempt
+		st.Failures = t.failures
+		st.NextAttemptAt = &nextAttempt
+	}
+	return st
+}
+
+// Run refreshes the tokens of every tenant with an active credential ahead of their
+// expiry until ctx is cancelled.
+func (m *TokenManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(tokenRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.refreshDue(ctx)
+	}
+}
+
+// refreshDue refreshes the tokens that expire within TokenRefreshBefore.
+func (m *TokenManager) refreshDue(ctx context.Context) {
+	tenantIDs, err := m.db.Collection("credentials").Distinct(ctx, "organization_id", bson.M{"active": true})
+	if err != nil {
+		return
+	}
+	validUntil := time.Now().Add(m.cfg.SatuSehat.TokenRefreshBefore)
+	for _, v := range tenantIDs {
+		if tenantID, ok := v.(string); ok && tenantID != "" {
+			_, _ = m.get(WithTenant(ctx, tenantID), tenantID, validUntil)
+		}
+	}
+}
+
+// get returns a token for tenantID that stays valid at least until
+// validUntil, refreshing it once for all concurrent callers when needed.
+func (m *TokenManager) get(ctx context.Context, tenantID string, validUntil time.Time) (tokenResult, error) {
+	if res, ok := m.fromCache(tenantID, validUntil); ok {
+		return res, nil
+	}
+
+	// The shared refresh must not die with whichever caller happened to start it.
+	ch := m.group.DoChan(tenantID, func() (interface{}, error) {
+		if res, ok := m.fromCache(tenantID, validUntil); ok {
+			return res, nil
+		}
+		return m.load(context.WithoutCancel(ctx), tenantID, validUntil)
+	})
+
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return tokenResult{}, r.Err
+		}
+		return r.Val.(tokenResult), nil
+	case <-ctx.Done():
+		return tokenResult{}, ctx.Err()
+	}
+}
+
+func (m *TokenManager) fromCache(tenantID string, validUntil time.Time) (tokenResult, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t := m.tenants[tenantID]
+	if t == nil || t.cached == nil || !t.cached.token.Expiry.After(validUntil) {
+		return tokenResult{}, false
+	}
+	return *t.cached, true
+}
+
+// tenant returns the state of tenantID, creating it. m.mu must be held for writing.
+func (m *TokenManager) tenant(tenantID string) *tenantToken {
+	t := m.tenants[tenantID]
+	if t == nil {
+		t = &tenantToken{}
+		m.tenants[tenantID] = t
+	}
+	return t
+}
+
+// load reads the credential and the stored token from Mongo, and only asks
+// the OAuth endpoint for a new token when the stored one is not good enough.
+func (m *TokenManager) load(ctx context.Context, tenantID string, validUntil time.Time) (tokenResult, error) {
+	res, attempted, err := m.loadOrGenerate(ctx, tenantID, validUntil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.tenant(tenantID)
+	if err != nil {
+		// OAuth failures are already recorded by recordAttempt
+		var cooldown *TokenCooldownError
+		if !attempted && !errors.As(err, &cooldown) {
+			t.lastErr = err
+			t.lastErrAt = time.Now()
+		}
+		return tokenResult{}, err
+	}
+	t.cached = &res
+	return res, nil
+}
+
+func (m *TokenManager) loadOrGenerate(ctx context.Context, tenantID string, validUntil time.Time) (tokenResult, bool, error) {
+	filter := bson.M{"organization_id": tenantID}
+
+	cred, err := ActiveCredential(ctx, m.db, tenantID)
+	if err != nil {
+		return tokenResult{}, false, err
+	}
+
+	profile, err := m.cfg.Profile(cred.Environment)
+	if err != nil {
+		return tokenResult{}, false, err
+	}
+	if cred.ClientSecret, err = cred.Secret(m.keys); err != nil {
+		return tokenResult{}, false, err
+	}
+
+	// Another instance may already have refreshed the token
+	var token Token
+	err = m.db.Collection("tokens").FindOne(ctx, filter).Decode(&token)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return tokenResult{}, false, err
+	}
+	if err := token.decrypt(m.keys); err != nil {
+		// Unreadable, e.g. sealed with a retired key: fetch a new one
+		token = Token{}
+	}
+	if token.AccessToken != "" && token.Environment == profile.Name && token.Expiry.After(validUntil) {
+		return tokenResult{token: token, profile: profile}, false, nil
+	}
+
+	if err := m.backoff(tenantID); err != nil {
+		return tokenResult{}, false, err
+	}
+
+	tokenCtx, cancel := context.WithTimeout(ctx, m.cfg.SatuSehat.TokenTimeout)
+	newToken, expiry, err := GenerateNewToken(tokenCtx, *cred, profile)
+	cancel()
+	m.recordAttempt(ctx, tenantID, profile, err)
+	if err != nil {
+		return tokenResult{}, true, err
+	}
+
+	token = Token{OrganizationID: tenantID, AccessToken: newToken, Expiry: expiry, Environment: profile.Name}
+	stored, err := token.sealed(m.keys)
+	if err != nil {
+		return tokenResult{}, true, err
+	}
+	_, err = m.db.Collection("tokens").ReplaceOne(ctx, filter, stored, options.Replace().SetUpsert(true))
+	if err != nil {
+		return tokenResult{}, true, err
+	}
+	return tokenResult{token: token, profile: profile}, true, nil
+}
+
+// backoff refuses to call the token endpoint while a previous failure's
+// backoff or cooldown is still running.
+func (m *TokenManager) backoff(tenantID string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t := m.tenants[tenantID]
+	if t == nil || t.failures == 0 || !time.Now().Before(t.nextAttempt) {
+		return nil
+	}
+	return &TokenCooldownError{Until: t.nextAttempt, Failures: t.failures, Err: t.lastErr}
+}
+
+// tokenRetryDelay is how long to wait after the given number of consecutive
+// failed OAuth calls.
+func tokenRetryDelay(failures int) time.Duration {
+	if failures >= tokenCooldownAfter {
+		return tokenCooldown
+	}
+	delay := tokenBackoffBase << (failures - 1)
+	if delay > tokenBackoffMax {
+		delay = tokenBackoffMax
+	}
+	return delay
+}
+
+// recordAttempt updates the backoff state after an OAuth call and writes it
+// to the audit log.
+func (m *TokenManager) recordAttempt(ctx context.Context, tenantID string, profile config.Profile, err error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	t := m.tenant(tenantID)
+	if err == nil {
+		t.failures = 0
+		t.nextAttempt = time.Time{}
+		t.lastRefresh = now
+		t.lastErr = nil
+	} else {
+		t.failures++
+		t.lastErr = err
+		t.lastErrAt = now
+		t.nextAttempt = now.Add(tokenRetryDelay(t.failures))
+	}
+	m.mu.Unlock()
+
+	entry := models.AuditLog{
+		User:           "system",
+		OrganizationID: tenantID,
+		Action:         "refresh",
+		Resource:       "token",
+		StatusCode:     http.StatusOK,
+		Details: map[string]interface{}{
+			"environment": profile.Name,
+		},
+	}
+	if err != nil {
+		entry.StatusCode = 0
+		entry.Details["error"] = err.Error()
+	}
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		entry.StatusCode = oauthErr.StatusCode
+		entry.Details["kind"] = oauthErr.Kind.Error()
+		entry.Details["message"] = oauthErr.Message
+	}
+	_ = LogAudit(ctx, m.db, entry)
+}
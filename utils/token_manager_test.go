@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/jaisyullah/satusehat-be-golang/config"
+	"github.com/jaisyullah/satusehat-be-golang/encryption"
+)
+
+const testTenant = "10000004"
+
+func testKeyring(t *testing.T) *encryption.Keyring {
+	t.Helper()
+	keys, err := encryption.NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// tokenServer is a token endpoint that counts the exchanges it answers.
+type tokenServer struct {
+	*httptest.Server
+	calls atomic.Int32
+}
+
+func newTokenServer(t *testing.T, handler func(n int32, w http.ResponseWriter)) *tokenServer {
+	ts := &tokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(ts.calls.Add(1), w)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func grantToken(lifetime time.Duration) func(n int32, w http.ResponseWriter) {
+	return func(n int32, w http.ResponseWriter) {
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":"%d"}`, n, int(lifetime.Seconds()))
+	}
+}
+
+func newTestManager(t *testing.T, mt *mtest.T, ts *tokenServer) *TokenManager {
+	cfg := config.Default()
+	cfg.SatuSehat.Profiles = map[string]config.Profile{"test": testProfile(ts.URL)}
+	cfg.SatuSehat.DefaultProfile = "test"
+	return NewTokenManager(mt.DB, cfg, testKeyring(t))
+}
+
+// Mock Mongo replies, in the order the TokenManager sends its commands.
+
+func credentialFound() bson.D {
+	return mtest.CreateCursorResponse(0, "satusehat.credentials", mtest.FirstBatch, bson.D{
+		{Key: "organization_id", Value: testTenant},
+		{Key: "active", Value: true},
+		{Key: "client_id", Value: "client"},
+		{Key: "client_secret", Value: "secret"},
+		{Key: "environment", Value: "test"},
+	})
+}
+
+func noStoredToken() bson.D {
+	return mtest.CreateCursorResponse(0, "satusehat.tokens", mtest.FirstBatch)
+}
+
+// refreshReplies answers one refresh that reaches the token endpoint:
+// credential, stored token, audit entry, token upsert.
+func refreshReplies() []bson.D {
+	return []bson.D{credentialFound(), noStoredToken(), mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1})}
+}
+
+func TestTokenManager(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	ctx := WithTenant(context.Background(), testTenant)
+
+	mt.Run("concurrent callers share one exchange", func(mt *mtest.T) {
+		ts := newTokenServer(mt.T, func(n int32, w http.ResponseWriter) {
+			time.Sleep(50 * time.Millisecond) // keep the exchange in flight while the others arrive
+			grantToken(time.Hour)(n, w)
+		})
+		m := newTestManager(mt.T, mt, ts)
+		mt.AddMockResponses(refreshReplies()...)
+
+		const callers = 20
+		var wg sync.WaitGroup
+		tokens := make([]string, callers)
+		errs := make([]error, callers)
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tokens[i], _, errs[i] = m.Token(ctx)
+			}(i)
+		}
+		wg.Wait()
+
+		if n := ts.calls.Load(); n != 1 {
+			mt.Fatalf("%d exchanges for %d concurrent callers, want 1 (first error: %v)", n, callers, errs[0])
+		}
+		for i := range tokens {
+			if errs[i] != nil || tokens[i] != "token-1" {
+				mt.Fatalf("caller %d got %q, %v", i, tokens[i], errs[i])
+			}
+		}
+	})
+
+	mt.Run("cached token is reused", func(mt *mtest.T) {
+		ts := newTokenServer(mt.T, grantToken(time.Hour))
+		m := newTestManager(mt.T, mt, ts)
+		mt.AddMockResponses(refreshReplies()...)
+
+		for i := 0; i < 3; i++ {
+			if _, _, err := m.Token(ctx); err != nil {
+				mt.Fatal(err)
+			}
+		}
+		if n := ts.calls.Load(); n != 1 {
+			mt.Fatalf("%d exchanges, want 1", n)
+		}
+	})
+
+	mt.Run("token is refreshed before it expires", func(mt *mtest.T) {
+		ts := newTokenServer(mt.T, grantToken(2*time.Minute))
+		m := newTestManager(mt.T, mt, ts)
+		m.cfg.SatuSehat.TokenRefreshBefore = 5 * time.Minute
+		mt.AddMockResponses(refreshReplies()...)
+
+		token, _, err := m.Token(ctx)
+		if err != nil || token != "token-1" {
+			mt.Fatalf("Token = %q, %v", token, err)
+		}
+		// Still valid for requests, so no exchange
+		if token, _, _ = m.Token(ctx); token != "token-1" || ts.calls.Load() != 1 {
+			mt.Fatalf("Token = %q after %d exchanges, want the cached token", token, ts.calls.Load())
+		}
+
+		// It expires within TokenRefreshBefore, so the background pass renews it
+		distinct := mtest.CreateSuccessResponse(bson.E{Key: "values", Value: bson.A{testTenant}})
+		mt.AddMockResponses(append([]bson.D{distinct}, refreshReplies()...)...)
+		m.refreshDue(context.Background())
+
+		if n := ts.calls.Load(); n != 2 {
+			mt.Fatalf("%d exchanges after the background pass, want 2", n)
+		}
+		if token, _, _ = m.Token(ctx); token != "token-2" {
+			mt.Fatalf("Token = %q, want the refreshed token-2", token)
+		}
+		st := m.Status()[testTenant]
+		if st.ExpiresAt == nil || time.Until(*st.ExpiresAt) < time.Minute || st.LastRefreshAt == nil {
+			mt.Fatalf("status = %+v", st)
+		}
+	})
+
+	mt.Run("server error backs off", func(mt *mtest.T) {
+		ts := newTokenServer(mt.T, func(_ int32, w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"fault":{"faultstring":"gateway down"}}`))
+		})
+		m := newTestManager(mt.T, mt, ts)
+		mt.AddMockResponses(credentialFound(), noStoredToken(), mtest.CreateSuccessResponse())
+
+		_, _, err := m.Token(ctx)
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) || !errors.Is(err, ErrTokenEndpointUnreachable) || oauthErr.StatusCode != http.StatusBadGateway {
+			mt.Fatalf("error = %v, want an unreachable OAuthError with HTTP 502", err)
+		}
+
+		// The retry waits out the backoff without calling the endpoint
+		mt.AddMockResponses(credentialFound(), noStoredToken())
+		_, _, err = m.Token(ctx)
+		var cooldown *TokenCooldownError
+		if !errors.As(err, &cooldown) {
+			mt.Fatalf("error = %v, want a TokenCooldownError", err)
+		}
+		if !errors.Is(err, ErrTokenEndpointUnreachable) {
+			mt.Errorf("cooldown error %v does not wrap the last failure", err)
+		}
+		if n := ts.calls.Load(); n != 1 {
+			mt.Fatalf("%d exchanges, want 1 during backoff", n)
+		}
+
+		st := m.Status()[testTenant]
+		if st.Failures != 1 || st.NextAttemptAt == nil || st.LastRefreshError == "" {
+			mt.Fatalf("status = %+v", st)
+		}
+		if wait := time.Until(*st.NextAttemptAt); wait <= 0 || wait > tokenBackoffBase {
+			mt.Errorf("next attempt in %s, want within the first backoff step (%s)", wait, tokenBackoffBase)
+		}
+	})
+
+	mt.Run("invalid client is reported", func(mt *mtest.T) {
+		ts := newTokenServer(mt.T, func(_ int32, w http.ResponseWriter) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+		})
+		m := newTestManager(mt.T, mt, ts)
+		mt.AddMockResponses(credentialFound(), noStoredToken(), mtest.CreateSuccessResponse())
+
+		_, _, err := m.Token(ctx)
+		if !errors.Is(err, ErrInvalidClient) {
+			mt.Fatalf("error = %v, want ErrInvalidClient", err)
+		}
+	})
+}
+
+func TestTokenBackoffSchedule(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{tokenCooldownAfter, tokenCooldown},
+		{tokenCooldownAfter + 3, tokenCooldown},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.failures), func(t *testing.T) {
+			if got := tokenRetryDelay(tt.failures); got != tt.want {
+				t.Errorf("delay after %d failures = %s, want %s", tt.failures, got, tt.want)
+			}
+		})
+	}
+}